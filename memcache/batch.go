@@ -0,0 +1,165 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// SetMulti is a batch version of Set. Items are grouped by server, and
+// the commands for each server are pipelined: they are all sent before
+// the first response is read, with the Zsolt protocol as frames back
+// to back. The servers are written to concurrently.
+//
+// The returned map holds the outcome for the key of every item, nil if
+// it was stored. If a server fails, its keys that weren't answered get
+// its error, and a MultiError is returned as well. If the same key is
+// given more than once, the outcome of the last set wins.
+func (c *Client) SetMulti(items []*Item) (map[string]error, error) {
+	keys := make([]string, len(items))
+	for i, it := range items {
+		keys[i] = it.Key
+	}
+	if c.Layout != nil || c.KeyTransform != nil {
+		sitems := make([]*Item, len(items))
+		for i, it := range items {
+			var err error
+			if sitems[i], err = c.fitLayout("set", c.serverItem(it)); err != nil {
+				return nil, err
+			}
+		}
+		items = sitems
+	}
+	skeys := c.serverKeys(keys)
+	defer c.invalidateNear(skeys...)
+	results, err := c.batch(skeys, func(w *bufio.Writer, i int) error {
+		return c.writeStore(w, "set", items[i])
+	}, func(line []byte) error {
+		return storeResult("set", line)
+	})
+	return c.clientResults(results, keys), err
+}
+
+// DeleteMulti is a batch version of Delete, pipelined per server like
+// SetMulti. The returned map holds the outcome for every key, nil if it
+// was deleted and ErrCacheMiss if it didn't exist.
+func (c *Client) DeleteMulti(keys []string) (map[string]error, error) {
+	skeys := c.serverKeys(keys)
+	defer c.invalidateNear(skeys...)
+	results, err := c.batch(skeys, func(w *bufio.Writer, i int) error {
+		return c.writeFramed(w, []byte("delete "), []byte(skeys[i]), crlf)
+	}, func(line []byte) error {
+		return deleteResult(line)
+	})
+	return c.clientResults(results, keys), err
+}
+
+// deleteResult returns the error for the response line to delete.
+func deleteResult(line []byte) error {
+	switch {
+	case bytes.Contains(line, resultDeleted):
+		return nil
+	case bytes.Contains(line, resultNotFound):
+		return ErrCacheMiss
+	}
+	if err := responseError("delete", line); err != nil {
+		return err
+	}
+	return fmt.Errorf("memcache: unexpected response line from delete: %q", string(line))
+}
+
+// batch groups keys by server and, for every server concurrently,
+// pipelines the commands written by write(w, i) for the indexes i of
+// its keys. The response line to every command is turned into the
+// key's outcome by result.
+func (c *Client) batch(keys []string, write func(w *bufio.Writer, i int) error, result func(line []byte) error) (map[string]error, error) {
+	byAddr := make(map[net.Addr][]int)
+	for i, key := range keys {
+		if !legalKey(key) {
+			return nil, ErrMalformedKey
+		}
+		addr, err := c.selector.PickServer(key)
+		if err != nil {
+			return nil, err
+		}
+		byAddr[addr] = append(byAddr[addr], i)
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]error, len(keys))
+	addrs := make([]net.Addr, 0, len(byAddr))
+	for addr := range byAddr {
+		addrs = append(addrs, addr)
+	}
+	err := fanOut(addrs, func(addr net.Addr) error {
+		idx := byAddr[addr]
+		outcomes := make([]error, len(idx))
+		answered := 0
+		err := c.pipeline(addr, len(idx), func(w *bufio.Writer, n int) error {
+			return write(w, idx[n])
+		}, func(r *bufio.Reader, n int) error {
+			line, err := c.readResponseLine(r)
+			if err != nil {
+				return err
+			}
+			outcomes[n] = result(line)
+			answered++
+			return nil
+		})
+		for n := answered; n < len(idx); n++ {
+			outcomes[n] = err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for n, i := range idx {
+			results[keys[i]] = outcomes[n]
+		}
+		return err
+	})
+	return results, err
+}
+
+// pipeline sends n commands to addr over one connection, written by
+// write, and reads the response to each of them with read. The commands
+// are flushed once, while the responses are read concurrently, so that
+// a server blocked on sending responses can't stall the writes.
+func (c *Client) pipeline(addr net.Addr, n int, write func(w *bufio.Writer, i int) error, read func(r *bufio.Reader, i int) error) error {
+	return c.withAddrConn(addr, func(cn *conn) error {
+		werr := make(chan error, 1)
+		go func() {
+			err := func() error {
+				for i := 0; i < n; i++ {
+					cn.extendWriteDeadline()
+					if err := write(cn.rw.Writer, i); err != nil {
+						return err
+					}
+				}
+				cn.extendWriteDeadline()
+				return cn.rw.Flush()
+			}()
+			if err != nil {
+				// Unblock the reader.
+				cn.nc.Close()
+			}
+			werr <- err
+		}()
+
+		var err error
+		for i := 0; i < n && err == nil; i++ {
+			cn.extendReadDeadline()
+			err = read(cn.rw.Reader, i)
+		}
+		if err != nil {
+			// Unblock the writer. If it failed first, its error
+			// is the cause of the reader's.
+			cn.nc.Close()
+			if we := <-werr; we != nil {
+				return we
+			}
+			return err
+		}
+		return <-werr
+	})
+}
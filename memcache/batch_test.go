@@ -0,0 +1,152 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storeHandler answers set with STORED, except for keys starting with
+// "nope", and delete with DELETED, except for keys starting with
+// "gone".
+func storeHandler(line string, r *bufio.Reader, w *bufio.Writer) {
+	f := strings.Fields(line)
+	switch f[0] {
+	case "set":
+		r.ReadString('\n')
+		if strings.HasPrefix(f[1], "nope") {
+			w.WriteString("NOT_STORED\r\n")
+		} else {
+			w.WriteString("STORED\r\n")
+		}
+	case "delete":
+		if strings.HasPrefix(f[1], "gone") {
+			w.WriteString("NOT_FOUND\r\n")
+		} else {
+			w.WriteString("DELETED\r\n")
+		}
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+}
+
+func TestSetMulti(t *testing.T) {
+	s1, s2 := newStubServer(t, storeHandler), newStubServer(t, storeHandler)
+	c := New(s1.Addr(), s2.Addr())
+	c.Timeout = time.Second
+
+	var items []*Item
+	for i := 0; i < 20; i++ {
+		items = append(items, &Item{Key: fmt.Sprintf("key%d", i), Value: []byte("v")})
+	}
+	items = append(items, &Item{Key: "nope", Value: []byte("v")})
+	results, err := c.SetMulti(items)
+	if err != nil {
+		t.Fatalf("SetMulti: %v", err)
+	}
+	if len(results) != len(items) {
+		t.Errorf("got %d results, want %d", len(results), len(items))
+	}
+	for key, err := range results {
+		want := error(nil)
+		if key == "nope" {
+			want = ErrNotStored
+		}
+		if err != want {
+			t.Errorf("%s: got %v, want %v", key, err, want)
+		}
+	}
+	if n := s1.Conns() + s2.Conns(); n != 2 {
+		t.Errorf("servers accepted %d connections, want one each", n)
+	}
+}
+
+func TestDeleteMulti(t *testing.T) {
+	s := newStubServer(t, storeHandler)
+	c := New(s.Addr())
+	c.Timeout = time.Second
+	results, err := c.DeleteMulti([]string{"a", "gone", "b"})
+	if err != nil {
+		t.Fatalf("DeleteMulti: %v", err)
+	}
+	if results["a"] != nil || results["b"] != nil || results["gone"] != ErrCacheMiss {
+		t.Errorf("DeleteMulti = %v", results)
+	}
+}
+
+func TestSetMultiLargeBatch(t *testing.T) {
+	// Enough data that the server's responses fill the socket buffers
+	// before all requests are written.
+	s := newStubServer(t, storeHandler)
+	c := New(s.Addr())
+	c.Timeout = time.Second
+	value := bytes.Repeat([]byte("x"), 1024)
+	items := make([]*Item, 5000)
+	for i := range items {
+		items[i] = &Item{Key: fmt.Sprintf("key%d", i), Value: value}
+	}
+	results, err := c.SetMulti(items)
+	if err != nil {
+		t.Fatalf("SetMulti: %v", err)
+	}
+	for key, err := range results {
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+	}
+}
+
+func TestSetMultiServerFailure(t *testing.T) {
+	good := newStubServer(t, storeHandler)
+	bad := newStubServer(t, storeHandler)
+	bad.ln.Close()
+	c := New(good.Addr(), bad.Addr())
+	c.Timeout = time.Second
+
+	var items []*Item
+	for i := 0; i < 20; i++ {
+		items = append(items, &Item{Key: fmt.Sprintf("key%d", i), Value: []byte("v")})
+	}
+	results, err := c.SetMulti(items)
+	var me MultiError
+	if !errors.As(err, &me) || len(me) != 1 {
+		t.Fatalf("SetMulti: got %v, want MultiError for one server", err)
+	}
+	var failed int
+	for _, err := range results {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed == 0 || failed == len(items) {
+		t.Errorf("%d of %d keys failed, want only those of the bad server", failed, len(items))
+	}
+}
+
+func TestSetMultiZsolt(t *testing.T) {
+	var frames []string
+	c := New("127.0.0.1:1")
+	c.UseZsolt = true
+	c.Dialer = zsoltDialer(func(payload []byte) []byte {
+		frames = append(frames, string(payload))
+		return []byte("STORED\r\n")
+	})
+	results, err := c.SetMulti([]*Item{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("22"), Flags: 3, Expiration: 60},
+	})
+	if err != nil {
+		t.Fatalf("SetMulti: %v", err)
+	}
+	if results["a"] != nil || results["b"] != nil {
+		t.Errorf("SetMulti = %v", results)
+	}
+	want := []string{"set a 0 0 1\r\n1\r\n", "set b 3 60 2\r\n22\r\n"}
+	if len(frames) != 2 || frames[0] != want[0] || frames[1] != want[1] {
+		t.Errorf("server got frames %q, want %q", frames, want)
+	}
+}
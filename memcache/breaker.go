@@ -0,0 +1,262 @@
+package memcache
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerWindow is the default period over which a circuit
+	// breaker computes the error rate of a server.
+	DefaultBreakerWindow = 10 * time.Second
+
+	// DefaultBreakerMinRequests is the default number of requests in
+	// the window below which a breaker doesn't trip.
+	DefaultBreakerMinRequests = 20
+
+	// DefaultBreakerErrorRate is the default fraction of failed requests
+	// that trips a breaker.
+	DefaultBreakerErrorRate = 0.5
+
+	// DefaultBreakerOpenDuration is the default time a tripped breaker
+	// rejects requests before letting probes through.
+	DefaultBreakerOpenDuration = time.Second
+
+	// breakerBuckets is the number of buckets the window is split into.
+	breakerBuckets = 10
+)
+
+// ErrCircuitOpen is matched by errors.Is for every *CircuitOpenError.
+var ErrCircuitOpen = errors.New("memcache: circuit open")
+
+// CircuitOpenError is returned, without contacting the server, for
+// requests to a server whose circuit breaker is open.
+type CircuitOpenError struct {
+	Addr net.Addr
+}
+
+func (ce *CircuitOpenError) Error() string {
+	return "memcache: circuit open for " + ce.Addr.String()
+}
+
+// Is reports whether target is ErrCircuitOpen.
+func (ce *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all requests.
+	BreakerOpen
+	// BreakerHalfOpen lets a limited number of probe requests through
+	// to decide whether to close again.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerPolicy configures a circuit breaker per server. A breaker opens
+// when, within Window, at least MinRequests requests were made and the
+// fraction of them that failed or took longer than SlowCall reaches
+// ErrorRate. After OpenDuration it lets HalfOpenRequests probes through;
+// if they all succeed it closes, otherwise it opens again.
+type BreakerPolicy struct {
+	// Window is the period the error rate is computed over. If zero,
+	// DefaultBreakerWindow is used. Windows shorter than 10ns, the
+	// number of buckets they are split into, are rounded up to it.
+	Window time.Duration
+
+	// MinRequests is the number of requests in Window needed before
+	// the breaker can open. If zero, DefaultBreakerMinRequests is used.
+	MinRequests int
+
+	// ErrorRate is the fraction of failed requests, in (0, 1], that
+	// opens the breaker. If zero, DefaultBreakerErrorRate is used.
+	ErrorRate float64
+
+	// SlowCall, if positive, is the latency above which a request
+	// counts as failed even if it succeeded.
+	SlowCall time.Duration
+
+	// OpenDuration is how long the breaker stays open. If zero,
+	// DefaultBreakerOpenDuration is used.
+	OpenDuration time.Duration
+
+	// HalfOpenRequests is the number of probes let through when half
+	// open. If zero, one probe is used.
+	HalfOpenRequests int
+
+	// OnStateChange, if non-nil, is called whenever the breaker of addr
+	// changes state. It runs with the breaker locked and must not send
+	// requests itself.
+	OnStateChange func(addr net.Addr, from, to BreakerState)
+}
+
+func (p *BreakerPolicy) window() time.Duration {
+	if p.Window > 0 {
+		return max(p.Window, breakerBuckets)
+	}
+	return DefaultBreakerWindow
+}
+
+func (p *BreakerPolicy) minRequests() int {
+	if p.MinRequests > 0 {
+		return p.MinRequests
+	}
+	return DefaultBreakerMinRequests
+}
+
+func (p *BreakerPolicy) errorRate() float64 {
+	if p.ErrorRate > 0 && p.ErrorRate <= 1 {
+		return p.ErrorRate
+	}
+	return DefaultBreakerErrorRate
+}
+
+func (p *BreakerPolicy) openDuration() time.Duration {
+	if p.OpenDuration > 0 {
+		return p.OpenDuration
+	}
+	return DefaultBreakerOpenDuration
+}
+
+func (p *BreakerPolicy) halfOpenRequests() int {
+	if p.HalfOpenRequests > 0 {
+		return p.HalfOpenRequests
+	}
+	return 1
+}
+
+// outcome is the result of a request as seen by a breaker.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeNeutral // the request never reached the server
+)
+
+type breakerBucket struct {
+	epoch  int64 // window-relative bucket number the counts belong to
+	total  int
+	failed int
+}
+
+// breaker is the circuit breaker of one server.
+type breaker struct {
+	mu        sync.Mutex
+	state     BreakerState
+	openedAt  time.Time
+	probes    int // probes let through while half open
+	successes int // successful probes while half open
+	buckets   [breakerBuckets]breakerBucket
+}
+
+// allow reports whether a request may be sent now.
+func (b *breaker) allow(p *BreakerPolicy, addr net.Addr, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		if now.Sub(b.openedAt) < p.openDuration() {
+			return false
+		}
+		b.setState(p, addr, BreakerHalfOpen)
+		b.probes, b.successes = 0, 0
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= p.halfOpenRequests() {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// record accounts for the outcome of a request let through by allow.
+func (b *breaker) record(p *BreakerPolicy, addr net.Addr, now time.Time, o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerHalfOpen:
+		switch o {
+		case outcomeNeutral:
+			b.probes--
+		case outcomeFailure:
+			b.trip(p, addr, now)
+		case outcomeSuccess:
+			if b.successes++; b.successes >= p.halfOpenRequests() {
+				b.buckets = [breakerBuckets]breakerBucket{}
+				b.setState(p, addr, BreakerClosed)
+			}
+		}
+	case BreakerClosed:
+		if o == outcomeNeutral {
+			return
+		}
+		width := p.window() / breakerBuckets
+		epoch := now.UnixNano() / int64(width)
+		bk := &b.buckets[epoch%breakerBuckets]
+		if bk.epoch != epoch {
+			*bk = breakerBucket{epoch: epoch}
+		}
+		bk.total++
+		if o == outcomeFailure {
+			bk.failed++
+		}
+
+		total, failed := 0, 0
+		for _, bk := range b.buckets {
+			if epoch-bk.epoch < breakerBuckets {
+				total += bk.total
+				failed += bk.failed
+			}
+		}
+		if total >= p.minRequests() && float64(failed) >= p.errorRate()*float64(total) {
+			b.trip(p, addr, now)
+		}
+	}
+}
+
+func (b *breaker) trip(p *BreakerPolicy, addr net.Addr, now time.Time) {
+	b.openedAt = now
+	b.setState(p, addr, BreakerOpen)
+}
+
+func (b *breaker) setState(p *BreakerPolicy, addr net.Addr, s BreakerState) {
+	if b.state == s {
+		return
+	}
+	from := b.state
+	b.state = s
+	if p.OnStateChange != nil {
+		p.OnStateChange(addr, from, s)
+	}
+}
+
+// allow returns a *CircuitOpenError if requests to addr are currently
+// rejected by its circuit breaker.
+func (c *Client) allow(addr net.Addr) error {
+	if c.Breaker == nil {
+		return nil
+	}
+	if !c.pool(addr).breaker.allow(c.Breaker, addr, time.Now()) {
+		return &CircuitOpenError{Addr: addr}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerTripsAndRecovers(t *testing.T) {
+	var down atomic.Bool
+	var dials atomic.Int32
+	pipe := pipeDialer(new(int))
+	c := New("127.0.0.1:1")
+	c.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		if down.Load() {
+			return nil, errInjected
+		}
+		return pipe(ctx, network, addr)
+	}
+
+	var mu sync.Mutex
+	var changes []BreakerState
+	c.Breaker = &BreakerPolicy{
+		MinRequests:  4,
+		ErrorRate:    0.5,
+		OpenDuration: 20 * time.Millisecond,
+		OnStateChange: func(addr net.Addr, from, to BreakerState) {
+			mu.Lock()
+			changes = append(changes, to)
+			mu.Unlock()
+		},
+	}
+
+	down.Store(true)
+	for i := 0; i < 4; i++ {
+		if _, err := c.Get("foo", 1); !errors.Is(err, errInjected) {
+			t.Fatalf("Get %d: got %v, want injected fault", i, err)
+		}
+	}
+	n := dials.Load()
+	_, err := c.Get("foo", 1)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get with open breaker: got %v, want ErrCircuitOpen", err)
+	}
+	if _, ok := err.(*CircuitOpenError); !ok {
+		t.Errorf("Get with open breaker: got %T, want *CircuitOpenError", err)
+	}
+	if dials.Load() != n {
+		t.Errorf("open breaker let a request through to the server")
+	}
+
+	down.Store(false)
+	time.Sleep(25 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("foo", 1); err != nil {
+			t.Fatalf("Get %d after recovery: %v", i, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("state changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("state changes = %v, want %v", changes, want)
+		}
+	}
+}
+
+func TestBreakerSlowCall(t *testing.T) {
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		time.Sleep(10 * time.Millisecond)
+		endHandler(line, r, w)
+	})
+	c := New(s.Addr())
+	c.Timeout = time.Second
+	c.Breaker = &BreakerPolicy{MinRequests: 2, SlowCall: time.Millisecond}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get("foo", 1); err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+	}
+	if _, err := c.Get("foo", 1); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get after slow calls: got %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	p := &BreakerPolicy{MinRequests: 2, OpenDuration: time.Second, HalfOpenRequests: 2}
+	addr := mustResolve(t, "127.0.0.1:1")
+	var b breaker
+	now := time.Now()
+
+	b.record(p, addr, now, outcomeFailure)
+	b.record(p, addr, now, outcomeSuccess)
+	if b.state != BreakerOpen {
+		t.Fatalf("state = %v after 50%% errors, want open", b.state)
+	}
+	if b.allow(p, addr, now.Add(time.Second/2)) {
+		t.Fatal("open breaker allowed a request")
+	}
+
+	now = now.Add(time.Second)
+	if !b.allow(p, addr, now) || !b.allow(p, addr, now) {
+		t.Fatal("half-open breaker rejected a probe")
+	}
+	if b.allow(p, addr, now) {
+		t.Fatal("half-open breaker allowed more than HalfOpenRequests probes")
+	}
+	b.record(p, addr, now, outcomeSuccess)
+	b.record(p, addr, now, outcomeFailure)
+	if b.state != BreakerOpen {
+		t.Fatalf("state = %v after a failed probe, want open", b.state)
+	}
+
+	now = now.Add(time.Second)
+	b.allow(p, addr, now)
+	b.allow(p, addr, now)
+	b.record(p, addr, now, outcomeSuccess)
+	b.record(p, addr, now, outcomeSuccess)
+	if b.state != BreakerClosed {
+		t.Fatalf("state = %v after successful probes, want closed", b.state)
+	}
+}
+
+func TestBreakerWindowExpires(t *testing.T) {
+	p := &BreakerPolicy{MinRequests: 2, Window: time.Second}
+	addr := mustResolve(t, "127.0.0.1:1")
+	var b breaker
+	now := time.Now()
+	b.record(p, addr, now, outcomeFailure)
+	// The first failure has left the window by now.
+	b.record(p, addr, now.Add(2*time.Second), outcomeFailure)
+	if b.state != BreakerClosed {
+		t.Fatalf("state = %v, want failures outside the window ignored", b.state)
+	}
+}
+
+func TestBreakerTinyWindow(t *testing.T) {
+	p := &BreakerPolicy{MinRequests: 2, Window: time.Nanosecond}
+	addr := mustResolve(t, "127.0.0.1:1")
+	var b breaker
+	now := time.Now()
+	b.record(p, addr, now, outcomeFailure)
+	b.record(p, addr, now, outcomeFailure)
+	if b.state != BreakerOpen {
+		t.Errorf("state = %v, want BreakerOpen", b.state)
+	}
+}
@@ -0,0 +1,93 @@
+package memcache
+
+import (
+	"bufio"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	s1 := newStubServer(t, endHandler)
+	s2 := newStubServer(t, endHandler)
+	c := New(s1.Addr(), s2.Addr())
+	c.MaxIdleConns = 4
+
+	if err := c.Warmup(3); err != nil {
+		t.Fatal(err)
+	}
+	// Warming up again only tops the pool up.
+	if err := c.Warmup(3); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*stubServer{s1, s2} {
+		// Accepts may lag behind the client's dials.
+		for i := 0; i < 100 && s.Conns() < 3; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if n := s.Conns(); n != 3 {
+			t.Errorf("server %s accepted %d connections, want 3", s.Addr(), n)
+		}
+		p := c.pool(s.ln.Addr())
+		p.mu.Lock()
+		idle := len(p.free)
+		p.mu.Unlock()
+		if idle != 3 {
+			t.Errorf("pool for %s holds %d idle connections, want 3", s.Addr(), idle)
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		if line == "get slow" {
+			close(started)
+			<-release
+		}
+		endHandler(line, r, w)
+	})
+	c := New(s.Addr())
+	c.Timeout = time.Second
+	if err := c.Warmup(2); err != nil {
+		t.Fatal(err)
+	}
+
+	getErr := make(chan error)
+	go func() {
+		_, err := c.Get("slow", 1)
+		getErr <- err
+	}()
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a request was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-getErr; err != nil {
+		t.Errorf("in-flight Get: %v", err)
+	}
+	<-closed
+
+	if _, err := c.Get("foo", 1); err != ErrClientClosed {
+		t.Errorf("Get after Close: got %v, want ErrClientClosed", err)
+	}
+	p := c.pool(s.ln.Addr())
+	p.mu.Lock()
+	idle, open := len(p.free), p.numOpen
+	p.mu.Unlock()
+	if idle != 0 || open != 0 {
+		t.Errorf("after Close: %d idle and %d open connections, want none", idle, open)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
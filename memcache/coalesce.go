@@ -0,0 +1,170 @@
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCoalesceWindow is the default time a request waits for
+	// others to share its Zsolt frame.
+	DefaultCoalesceWindow = 20 * time.Microsecond
+)
+
+// CoalescePolicy configures the coalescing of concurrent requests to
+// the same server into one Zsolt frame, saving a header and padding per
+// request. The server must accept several commands per frame and answer
+// each of them, in order, as it would a frame of its own.
+//
+// Get requests and storage commands (Set, Add, Replace, Append,
+// Prepend, CompareAndSwap) are coalesced. Coalescing only applies with
+// UseZsolt, and not to hedged requests.
+type CoalescePolicy struct {
+	// Window is how long the first request of a frame waits for
+	// others before the frame is sent. If zero,
+	// DefaultCoalesceWindow is used.
+	Window time.Duration
+
+	// MaxBytes sends a frame as soon as its payload reaches this size.
+	// If zero or larger than a frame can carry, frames are filled up
+	// to their maximum of 2040 bytes.
+	MaxBytes int
+}
+
+func (p *CoalescePolicy) window() time.Duration {
+	if p.Window > 0 {
+		return p.Window
+	}
+	return DefaultCoalesceWindow
+}
+
+func (p *CoalescePolicy) maxBytes() int {
+	if p.MaxBytes > 0 && p.MaxBytes < maxZsoltPayload {
+		return p.MaxBytes
+	}
+	return maxZsoltPayload
+}
+
+// coalescing reports whether requests are coalesced into shared frames.
+func (c *Client) coalescing() bool {
+	return c.Coalesce != nil && c.UseZsolt
+}
+
+// coalescedReq is a request waiting to be sent in a shared frame.
+type coalescedReq struct {
+	cmd  []byte                    // the command and its data block
+	read func(*bufio.Reader) error // reads the command's response
+	done chan error
+}
+
+// coalescer gathers the requests to one server into frames.
+type coalescer struct {
+	mu      sync.Mutex
+	pending []*coalescedReq
+	size    int // payload bytes of pending
+	timer   *time.Timer
+}
+
+// take removes and returns the pending requests. co.mu must be held.
+func (co *coalescer) take() []*coalescedReq {
+	if co.timer != nil {
+		co.timer.Stop()
+		co.timer = nil
+	}
+	batch := co.pending
+	co.pending, co.size = nil, 0
+	return batch
+}
+
+// coalesce sends cmd to addr in a frame shared with other requests and
+// returns the error of read, which is called with the connection's
+// reader once the responses to the requests before it have been read.
+func (c *Client) coalesce(addr net.Addr, cmd []byte, read func(*bufio.Reader) error) error {
+	if len(cmd) > maxZsoltPayload {
+		return ErrFrameTooLarge
+	}
+	req := &coalescedReq{cmd: cmd, read: read, done: make(chan error, 1)}
+	p := c.pool(addr)
+	co := &p.coalescer
+	max := c.Coalesce.maxBytes()
+
+	var full [][]*coalescedReq
+	co.mu.Lock()
+	if len(co.pending) > 0 && co.size+len(cmd) > max {
+		full = append(full, co.take())
+	}
+	co.pending = append(co.pending, req)
+	co.size += len(cmd)
+	if co.size >= max {
+		full = append(full, co.take())
+	} else if len(co.pending) == 1 {
+		co.timer = time.AfterFunc(c.Coalesce.window(), func() {
+			co.mu.Lock()
+			batch := co.take()
+			co.mu.Unlock()
+			c.sendCoalesced(addr, batch)
+		})
+	}
+	co.mu.Unlock()
+
+	// A request that fills a frame sends it itself.
+	for _, batch := range full {
+		c.sendCoalesced(addr, batch)
+	}
+	return <-req.done
+}
+
+// sendCoalesced sends batch in one frame and hands every request the
+// outcome of reading its response.
+func (c *Client) sendCoalesced(addr net.Addr, batch []*coalescedReq) {
+	if len(batch) == 0 {
+		return
+	}
+	answered := 0
+	err := c.withAddrConn(addr, func(cn *conn) error {
+		parts := make([][]byte, len(batch))
+		for i, req := range batch {
+			parts[i] = req.cmd
+		}
+		if err := c.writeFramed(cn.rw.Writer, parts...); err != nil {
+			return err
+		}
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+		for _, req := range batch {
+			cn.extendReadDeadline()
+			err := req.read(cn.rw.Reader)
+			if err != nil && !resumableError(err) {
+				return err
+			}
+			req.done <- err
+			answered++
+		}
+		return nil
+	})
+	for _, req := range batch[answered:] {
+		req.done <- err
+	}
+}
+
+// getCoalesced is getFromAddr for coalesced requests. The items are
+// handed to cb once the request is done, so that cb doesn't have to
+// outlive the call, and Get doesn't allocate for it when requests
+// aren't coalesced.
+func (c *Client) getCoalesced(addr net.Addr, keys []string, cb func(*Item), scancount int) error {
+	var items []*Item
+	cmd := appendGetLine(nil, keys)
+	err := c.coalesce(addr, cmd, func(r *bufio.Reader) error {
+		return readScan(scancount, func() error {
+			err := parseZsoltResponse(r, func(it *Item) { items = append(items, it) })
+			return annotateError(err, "get", nil)
+		})
+	})
+	for _, it := range items {
+		cb(it)
+	}
+	return err
+}
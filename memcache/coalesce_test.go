@@ -0,0 +1,136 @@
+package memcache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	fm := newFakeMemcache()
+	var frames atomic.Int32
+	c := New("127.0.0.1:1")
+	c.Timeout = time.Second
+	c.UseZsolt = true
+	c.Coalesce = &CoalescePolicy{Window: 5 * time.Millisecond}
+	c.Dialer = zsoltDialer(func(payload []byte) []byte {
+		frames.Add(1)
+		return fm.zsoltHandler(payload)
+	})
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			if err := c.Set(&Item{Key: key, Value: []byte("v")}); err != nil {
+				t.Errorf("Set(%s): %v", key, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := frames.Load(); got >= n {
+		t.Errorf("%d sets were sent in %d frames, want them coalesced", n, got)
+	}
+	for i := 0; i < n; i++ {
+		if _, ok := fm.item(fmt.Sprintf("key%d", i)); !ok {
+			t.Errorf("key%d was not stored", i)
+		}
+	}
+
+	frames.Store(0)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get("key0", 1); err != nil {
+				t.Errorf("Get: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := frames.Load(); got >= n {
+		t.Errorf("%d gets were sent in %d frames, want them coalesced", n, got)
+	}
+}
+
+func TestCoalesceMaxBytes(t *testing.T) {
+	fm := newFakeMemcache()
+	var frames atomic.Int32
+	c := New("127.0.0.1:1")
+	c.Timeout = time.Second
+	c.UseZsolt = true
+	// Every command fills a frame, so none waits for the window.
+	c.Coalesce = &CoalescePolicy{Window: time.Hour, MaxBytes: 1}
+	c.Dialer = zsoltDialer(func(payload []byte) []byte {
+		frames.Add(1)
+		return fm.zsoltHandler(payload)
+	})
+	if err := c.Set(&Item{Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := c.Append(&Item{Key: "missing", Value: []byte("v")}); err != ErrNotStored {
+		t.Fatalf("Append: got %v, want ErrNotStored", err)
+	}
+	if got := frames.Load(); got != 2 {
+		t.Errorf("sent %d frames, want 2", got)
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	for _, coalesce := range []bool{false, true} {
+		fm := newFakeMemcache()
+		var frames atomic.Int32
+		c := New("127.0.0.1:1")
+		c.Timeout = time.Second
+		c.UseZsolt = true
+		if coalesce {
+			c.Coalesce = &CoalescePolicy{}
+		}
+		c.Dialer = zsoltDialer(func(payload []byte) []byte {
+			frames.Add(1)
+			return fm.zsoltHandler(payload)
+		})
+		item := &Item{Key: "big", Value: make([]byte, maxZsoltPayload)}
+		if err := c.Set(item); err != ErrFrameTooLarge {
+			t.Errorf("coalesce=%v: Set of %d bytes: got %v, want ErrFrameTooLarge", coalesce, len(item.Value), err)
+		}
+		if got := frames.Load(); got != 0 {
+			t.Errorf("coalesce=%v: sent %d frames, want 0", coalesce, got)
+		}
+		if err := c.Set(&Item{Key: "small", Value: []byte("v")}); err != nil {
+			t.Errorf("coalesce=%v: Set after ErrFrameTooLarge: %v", coalesce, err)
+		}
+	}
+}
+
+func BenchmarkGetCoalesced(b *testing.B) {
+	for _, coalesce := range []bool{false, true} {
+		name := "uncoalesced"
+		if coalesce {
+			name = "coalesced"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := benchClient(b, true, 1, "END\r\n")
+			if coalesce {
+				c.Coalesce = &CoalescePolicy{}
+			}
+			// Coalescing only pays off with concurrent requests.
+			b.SetParallelism(16)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := c.Get("0123456789abcdef", 1); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
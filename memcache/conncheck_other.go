@@ -0,0 +1,10 @@
+//go:build !unix
+
+package memcache
+
+import "net"
+
+// connAlive is not supported on this platform and assumes nc is alive.
+func connAlive(nc net.Conn) bool {
+	return true
+}
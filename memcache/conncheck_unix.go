@@ -0,0 +1,33 @@
+//go:build unix
+
+package memcache
+
+import (
+	"net"
+	"syscall"
+)
+
+// connAlive reports whether nc is still open and has no pending data,
+// using a non-blocking read on its socket. Connections that don't
+// expose their socket are assumed to be alive.
+func connAlive(nc net.Conn) bool {
+	sc, ok := nc.(syscall.Conn)
+	if !ok {
+		return true
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	alive := false
+	var buf [1]byte
+	err = rc.Read(func(fd uintptr) bool {
+		n, err := syscall.Read(int(fd), buf[:])
+		// EAGAIN means there is nothing to read and the peer hasn't
+		// closed the connection. EOF and unexpected bytes both make
+		// the connection unusable.
+		alive = n < 0 && (err == syscall.EAGAIN || err == syscall.EWOULDBLOCK)
+		return true
+	})
+	return err == nil && alive
+}
@@ -0,0 +1,113 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipeDialer returns a Dialer that connects to an in-memory server
+// answering every request line with END.
+func pipeDialer(dials *int) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		*dials++
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			r := bufio.NewReader(server)
+			for {
+				if _, err := r.ReadString('\n'); err != nil {
+					return
+				}
+				if _, err := server.Write([]byte("END\r\n")); err != nil {
+					return
+				}
+			}
+		}()
+		return client, nil
+	}
+}
+
+func TestDialerPipe(t *testing.T) {
+	var dials int
+	c := New("127.0.0.1:1") // never dialed over the network
+	c.Dialer = pipeDialer(&dials)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("foo", 1); err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("dialed %d times, want 1", dials)
+	}
+}
+
+// faultyConn fails every write after the first n with a network error.
+type faultyConn struct {
+	net.Conn
+	n int
+}
+
+var errInjected = errors.New("injected fault")
+
+func (fc *faultyConn) Write(p []byte) (int, error) {
+	if fc.n <= 0 {
+		return 0, &net.OpError{Op: "write", Net: "pipe", Err: errInjected}
+	}
+	fc.n--
+	return fc.Conn.Write(p)
+}
+
+func TestDialerFaultInjection(t *testing.T) {
+	var dials int
+	pipe := pipeDialer(&dials)
+	c := New("127.0.0.1:1")
+	c.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		nc, err := pipe(ctx, network, addr)
+		return &faultyConn{Conn: nc, n: 1}, err
+	}
+	if _, err := c.Get("foo", 1); err != nil {
+		t.Fatalf("first Get: %v", err)
+	}
+	if _, err := c.Get("foo", 1); !errors.Is(err, errInjected) {
+		t.Fatalf("second Get: got %v, want injected fault", err)
+	}
+	// The failed connection must not be reused.
+	if _, err := c.Get("foo", 1); err != nil {
+		t.Fatalf("third Get: %v", err)
+	}
+	if dials != 2 {
+		t.Errorf("dialed %d times, want 2", dials)
+	}
+}
+
+func TestDialerTimeout(t *testing.T) {
+	c := New("127.0.0.1:1")
+	c.Timeout = 10 * time.Millisecond
+	c.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	_, err := c.Get("foo", 1)
+	if _, ok := err.(*ConnectTimeoutError); !ok {
+		t.Fatalf("got %v, want *ConnectTimeoutError", err)
+	}
+}
+
+func TestTCPOptions(t *testing.T) {
+	s := newStubServer(t, endHandler)
+	for _, opts := range []*TCPOptions{
+		{DisableNoDelay: true, KeepAlive: 30 * time.Second, ReadBuffer: 64 << 10, WriteBuffer: 64 << 10},
+		{KeepAlive: -1},
+	} {
+		c := New(s.Addr())
+		c.TCP = opts
+		if _, err := c.Get("foo", 1); err != nil {
+			t.Errorf("Get with %+v: %v", *opts, err)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,55 @@
+//go:build unix
+
+package memcache
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func noDelay(t *testing.T, tc *net.TCPConn) bool {
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return v != 0
+}
+
+func TestTCPOptionsNoDelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("skipping test; can't listen on loopback: %v", err)
+	}
+	defer ln.Close()
+	for _, tt := range []struct {
+		opts *TCPOptions
+		want bool
+	}{
+		{&TCPOptions{ReadBuffer: 64 << 10}, true},
+		{&TCPOptions{DisableNoDelay: true}, false},
+	} {
+		nc, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc := nc.(*net.TCPConn)
+		if err := tt.opts.apply(tc); err != nil {
+			t.Fatalf("apply(%+v): %v", *tt.opts, err)
+		}
+		if got := noDelay(t, tc); got != tt.want {
+			t.Errorf("with %+v, TCP_NODELAY = %v, want %v", *tt.opts, got, tt.want)
+		}
+		nc.Close()
+	}
+}
@@ -0,0 +1,236 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDiscoveryInterval is the default refresh interval of a Watcher.
+const DefaultDiscoveryInterval = 10 * time.Second
+
+// ErrNoDiscoveredServers is returned when a discovery source yields an
+// empty server list. Watchers never apply an empty list, so that a
+// truncated file or a failed lookup can't take every server away.
+var ErrNoDiscoveredServers = errors.New("memcache: discovery returned no servers")
+
+// ServerSource produces the current list of servers from some source of
+// truth, such as a file or DNS.
+type ServerSource interface {
+	Servers(ctx context.Context) ([]string, error)
+}
+
+// ServerUpdater is implemented by selectors whose servers can be replaced
+// at runtime, such as *ServerList. SetServers must apply all servers or
+// none of them.
+type ServerUpdater interface {
+	SetServers(servers ...string) error
+}
+
+// FileSource reads servers from a local file. The file either holds a
+// JSON array of addresses, or one address per line; in the latter form
+// blank lines and lines starting with '#' are ignored.
+type FileSource struct {
+	Path string
+}
+
+// Servers reads and parses the file.
+func (fs *FileSource) Servers(ctx context.Context) ([]string, error) {
+	b, err := os.ReadFile(fs.Path)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	if bytes.HasPrefix(b, []byte("[")) {
+		var servers []string
+		if err := json.Unmarshal(b, &servers); err != nil {
+			return nil, fmt.Errorf("memcache: parsing server file %s: %v", fs.Path, err)
+		}
+		return servers, nil
+	}
+	var servers []string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		servers = append(servers, line)
+	}
+	return servers, sc.Err()
+}
+
+// Resolver is the subset of *net.Resolver used by DNSSource.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNSSource discovers servers through DNS. If Service is set, the SRV
+// records _Service._Proto.Name are looked up and each target is used
+// with the port from its record. Otherwise the A/AAAA records of Name
+// are used with Port.
+type DNSSource struct {
+	Name    string
+	Service string
+	Proto   string // defaults to "tcp"
+	Port    int
+
+	// Resolver is used for lookups. If nil, net.DefaultResolver is used.
+	Resolver Resolver
+}
+
+func (ds *DNSSource) resolver() Resolver {
+	if ds.Resolver != nil {
+		return ds.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// Servers looks up the source's records and returns the sorted
+// addresses.
+func (ds *DNSSource) Servers(ctx context.Context) ([]string, error) {
+	var servers []string
+	if ds.Service != "" {
+		proto := ds.Proto
+		if proto == "" {
+			proto = "tcp"
+		}
+		_, srvs, err := ds.resolver().LookupSRV(ctx, ds.Service, proto, ds.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			servers = append(servers, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+	} else {
+		hosts, err := ds.resolver().LookupHost(ctx, ds.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			servers = append(servers, net.JoinHostPort(host, strconv.Itoa(ds.Port)))
+		}
+	}
+	sort.Strings(servers)
+	return servers, nil
+}
+
+// Watcher keeps a selector's servers in sync with a ServerSource.
+type Watcher struct {
+	Source   ServerSource
+	Selector ServerUpdater
+
+	// Interval is the time between refreshes. If zero,
+	// DefaultDiscoveryInterval is used.
+	Interval time.Duration
+
+	// OnChange, if non-nil, is called after a new server list has been
+	// applied, with the servers that joined and left.
+	OnChange func(added, removed []string)
+
+	// OnError, if non-nil, is called by Run when a refresh fails. The
+	// previous server list stays in place.
+	OnError func(error)
+
+	mu      sync.Mutex
+	current []string
+}
+
+func (w *Watcher) interval() time.Duration {
+	if w.Interval > 0 {
+		return w.Interval
+	}
+	return DefaultDiscoveryInterval
+}
+
+// Refresh reads the source once and applies the result to the selector
+// if the server list changed.
+func (w *Watcher) Refresh(ctx context.Context) error {
+	servers, err := w.Source.Servers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		return ErrNoDiscoveredServers
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if equalServers(w.current, servers) {
+		return nil
+	}
+	if err := w.Selector.SetServers(servers...); err != nil {
+		return err
+	}
+	added, removed := diffServers(w.current, servers)
+	w.current = append([]string(nil), servers...)
+	if w.OnChange != nil {
+		w.OnChange(added, removed)
+	}
+	return nil
+}
+
+// Run refreshes the selector immediately and then every Interval until
+// ctx is done. It returns ctx.Err().
+func (w *Watcher) Run(ctx context.Context) error {
+	t := time.NewTicker(w.interval())
+	defer t.Stop()
+	for {
+		if err := w.Refresh(ctx); err != nil && w.OnError != nil {
+			w.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func equalServers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffServers returns the servers in next but not in prev, and those in
+// prev but not in next, each sorted and without duplicates.
+func diffServers(prev, next []string) (added, removed []string) {
+	inPrev := make(map[string]bool, len(prev))
+	for _, s := range prev {
+		inPrev[s] = true
+	}
+	inNext := make(map[string]bool, len(next))
+	for _, s := range next {
+		if !inNext[s] && !inPrev[s] {
+			added = append(added, s)
+		}
+		inNext[s] = true
+	}
+	for s := range inPrev {
+		if !inNext[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
@@ -0,0 +1,135 @@
+package memcache
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"10.0.0.1:11211\n\n# rack b\n10.0.0.2:11211\n", []string{"10.0.0.1:11211", "10.0.0.2:11211"}},
+		{`["10.0.0.3:11211", "10.0.0.4:11211"]`, []string{"10.0.0.3:11211", "10.0.0.4:11211"}},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, "servers")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := (&FileSource{Path: path}).Servers(context.Background())
+		if err != nil {
+			t.Fatalf("%d: Servers: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: Servers = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestWatcherAppliesChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ss ServerList
+	var added, removed []string
+	w := &Watcher{
+		Source:   &FileSource{Path: path},
+		Selector: &ss,
+		OnChange: func(a, r []string) { added, removed = a, r },
+	}
+	ctx := context.Background()
+
+	write("127.0.0.1:1\n127.0.0.1:2\n")
+	if err := w.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"127.0.0.1:1", "127.0.0.1:2"}; !reflect.DeepEqual(added, want) || removed != nil {
+		t.Errorf("first refresh: added %q removed %q, want added %q", added, removed, want)
+	}
+
+	write("127.0.0.1:2\n127.0.0.1:3\n")
+	if err := w.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(added, []string{"127.0.0.1:3"}) || !reflect.DeepEqual(removed, []string{"127.0.0.1:1"}) {
+		t.Errorf("second refresh: added %q removed %q", added, removed)
+	}
+	var got []string
+	ss.Each(func(a net.Addr) error {
+		got = append(got, a.String())
+		return nil
+	})
+	if want := []string{"127.0.0.1:2", "127.0.0.1:3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selector servers = %q, want %q", got, want)
+	}
+
+	// Neither an empty file nor an unresolvable server may change the
+	// selector.
+	write("")
+	if err := w.Refresh(ctx); err != ErrNoDiscoveredServers {
+		t.Errorf("empty file: got %v, want ErrNoDiscoveredServers", err)
+	}
+	write("127.0.0.1:4\nno-such-host.invalid:1\n")
+	if err := w.Refresh(ctx); err == nil {
+		t.Errorf("unresolvable server: want error")
+	}
+	got = got[:0]
+	ss.Each(func(a net.Addr) error {
+		got = append(got, a.String())
+		return nil
+	})
+	if want := []string{"127.0.0.1:2", "127.0.0.1:3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selector servers after failed refresh = %q, want %q", got, want)
+	}
+}
+
+type stubResolver struct {
+	srvs  []*net.SRV
+	hosts []string
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return "_" + service + "._" + proto + "." + name, r.srvs, nil
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.hosts, nil
+}
+
+func TestDNSSource(t *testing.T) {
+	r := &stubResolver{
+		srvs: []*net.SRV{
+			{Target: "fpga2.example.com.", Port: 2888},
+			{Target: "fpga1.example.com.", Port: 2888},
+		},
+		hosts: []string{"10.1.212.210", "10.1.212.209"},
+	}
+	ctx := context.Background()
+
+	got, err := (&DNSSource{Name: "example.com", Service: "memcache", Resolver: r}).Servers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fpga1.example.com:2888", "fpga2.example.com:2888"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SRV servers = %q, want %q", got, want)
+	}
+
+	got, err = (&DNSSource{Name: "fpga.example.com", Port: 2888, Resolver: r}).Servers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.1.212.209:2888", "10.1.212.210:2888"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("A servers = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+)
+
+const (
+	// zsoltHeaderLen is the length of a Zsolt frame header over TCP.
+	zsoltHeaderLen = 16
+
+	// zsoltUDPHeaderLen is the length of a Zsolt frame header over UDP.
+	zsoltUDPHeaderLen = 8
+)
+
+// crlfPadding is the CRLF that ends a data block, followed by enough
+// zeros to pad any Zsolt frame.
+var crlfPadding = [10]byte{'\r', '\n'}
+
+// reqBuf holds an encoded request. Buffers are reused through
+// reqBufPool, so that encoding a request doesn't allocate.
+type reqBuf struct {
+	zheader [zsoltHeaderLen]byte
+	line    []byte // command line, with CRLF
+	vec     [4][]byte
+	bufs    net.Buffers
+}
+
+var reqBufPool = sync.Pool{
+	New: func() interface{} {
+		return &reqBuf{line: make([]byte, 0, 128)}
+	},
+}
+
+func getReqBuf() *reqBuf {
+	rb := reqBufPool.Get().(*reqBuf)
+	rb.line = rb.line[:0]
+	return rb
+}
+
+func putReqBuf(rb *reqBuf) {
+	rb.vec = [4][]byte{}
+	rb.bufs = nil
+	reqBufPool.Put(rb)
+}
+
+// frame returns the pieces of a request made of rb.line and, if hasData,
+// the data block data: the Zsolt header of hdrLen bytes if the client
+// uses the Zsolt protocol, the command line, the data block and the
+// CRLF ending it together with the frame's padding. ErrFrameTooLarge is
+// returned if the request doesn't fit in a Zsolt frame.
+func (c *Client) frame(rb *reqBuf, hdrLen int, data []byte, hasData bool) (net.Buffers, error) {
+	length := len(rb.line)
+	if hasData {
+		length += len(data) + 2
+	}
+	padLen := 0
+	if c.UseZsolt && length%8 != 0 {
+		padLen = 8 - length%8
+	}
+	if c.UseZsolt && length+padLen > maxZsoltPayload {
+		return nil, ErrFrameTooLarge
+	}
+	v := rb.vec[:0]
+	if c.UseZsolt {
+		rb.zheader = [zsoltHeaderLen]byte{0xFF, 0xFF, 0, 0, byte((length + padLen) / 8)}
+		v = append(v, rb.zheader[:hdrLen])
+	}
+	v = append(v, rb.line)
+	if hasData {
+		v = append(v, data, crlfPadding[:2+padLen])
+	} else if padLen > 0 {
+		v = append(v, zsoltPadding[:padLen])
+	}
+	rb.bufs = v
+	return rb.bufs, nil
+}
+
+// send writes the request in rb to cn with a single vectored write.
+func (c *Client) send(cn *conn, rb *reqBuf, data []byte, hasData bool) error {
+	if cn.rw.Writer.Buffered() > 0 {
+		if err := cn.rw.Flush(); err != nil {
+			return err
+		}
+	}
+	if _, err := c.frame(rb, zsoltHeaderLen, data, hasData); err != nil {
+		return err
+	}
+	_, err := rb.bufs.WriteTo(cn.nc)
+	return err
+}
+
+// sendBuffered writes the request in rb to w, with a Zsolt header of
+// hdrLen bytes. It doesn't flush w.
+func (c *Client) sendBuffered(w *bufio.Writer, rb *reqBuf, hdrLen int, data []byte, hasData bool) error {
+	bufs, err := c.frame(rb, hdrLen, data, hasData)
+	if err != nil {
+		return err
+	}
+	for _, b := range bufs {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendGetLine appends the command line of a get for keys to b.
+func appendGetLine(b []byte, keys []string) []byte {
+	b = append(b, "get"...)
+	for _, key := range keys {
+		b = append(b, ' ')
+		b = append(b, key...)
+	}
+	return append(b, crlf...)
+}
+
+// appendDataLine appends the command line "verb key 0 0 n", of a
+// command with a data block of n bytes and no flags or expiration, to b.
+func appendDataLine(b []byte, verb, key string, n int) []byte {
+	b = append(b, verb...)
+	b = append(b, ' ')
+	b = append(b, key...)
+	b = append(b, " 0 0 "...)
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, crlf...)
+}
+
+// appendStoreLine appends the command line of the storage command verb
+// for item to b.
+func appendStoreLine(b []byte, verb string, item *Item) []byte {
+	b = append(b, verb...)
+	b = append(b, ' ')
+	b = append(b, item.Key...)
+	b = append(b, ' ')
+	b = strconv.AppendUint(b, uint64(item.Flags), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(item.Expiration), 10)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(len(item.Value)), 10)
+	if verb == "cas" {
+		b = append(b, ' ')
+		b = strconv.AppendUint(b, item.casid, 10)
+	}
+	return append(b, crlf...)
+}
@@ -0,0 +1,172 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	c := &Client{UseZsolt: true}
+	rb := getReqBuf()
+	defer putReqBuf(rb)
+	rb.line = appendGetLine(rb.line, []string{"foo"})
+	var buf bytes.Buffer
+	bufs, err := c.frame(rb, zsoltHeaderLen, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range bufs {
+		buf.Write(b)
+	}
+	want := append([]byte{0xFF, 0xFF, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		"get foo\r\n\x00\x00\x00\x00\x00\x00\x00"...)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("get frame = %q, want %q", buf.Bytes(), want)
+	}
+
+	rb.line = appendStoreLine(rb.line[:0], "set", &Item{Key: "k", Value: []byte("vv"), Flags: 1, Expiration: 2})
+	buf.Reset()
+	bufs, err = c.frame(rb, zsoltUDPHeaderLen, []byte("vv"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range bufs {
+		buf.Write(b)
+	}
+	want = append([]byte{0xFF, 0xFF, 0, 0, 3, 0, 0, 0}, "set k 1 2 2\r\nvv\r\n\x00\x00\x00\x00\x00\x00\x00"...)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("set frame = %q, want %q", buf.Bytes(), want)
+	}
+}
+
+func TestEncodeAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("skipping allocation test with the race detector")
+	}
+	item := &Item{Key: "0123456789abcdef", Value: make([]byte, 64), Flags: 3, Expiration: 60}
+	w := bufio.NewWriter(io.Discard)
+	for _, zsolt := range []bool{false, true} {
+		c := &Client{UseZsolt: zsolt}
+		allocs := testing.AllocsPerRun(100, func() {
+			c.writeStore(w, "set", item)
+			rb := getReqBuf()
+			rb.line = appendGetLine(rb.line, []string{item.Key})
+			c.sendBuffered(w, rb, zsoltHeaderLen, nil, false)
+			putReqBuf(rb)
+		})
+		if allocs != 0 {
+			t.Errorf("UseZsolt=%v: encoding allocated %v times per request, want 0", zsolt, allocs)
+		}
+	}
+}
+
+// benchServer is a memcached stand-in that answers every request with
+// the same reply without allocating, so that benchmarks only count the
+// client's allocations. Plain requests are told apart by their number
+// of lines, as are the requests sharing a Zsolt frame.
+func benchServer(b *testing.B, zsolt bool, linesPerReq int, reply string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Skipf("skipping benchmark; can't listen on loopback: %v", err)
+	}
+	b.Cleanup(func() { ln.Close() })
+	resp := []byte(reply)
+	if zsolt {
+		resp = append(make([]byte, 8), resp...)
+	}
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer nc.Close()
+				r := bufio.NewReaderSize(nc, 64<<10)
+				header := make([]byte, zsoltHeaderLen)
+				payload := make([]byte, maxZsoltPayload)
+				lines := 0
+				for {
+					if zsolt {
+						if _, err := io.ReadFull(r, header); err != nil {
+							return
+						}
+						// A frame may carry several coalesced requests.
+						payload := payload[:int(header[4])*8]
+						if _, err := io.ReadFull(r, payload); err != nil {
+							return
+						}
+						for n := bytes.Count(payload, crlf) / linesPerReq; n > 1; n-- {
+							if _, err := nc.Write(resp); err != nil {
+								return
+							}
+						}
+					} else {
+						if _, err := r.ReadSlice('\n'); err != nil {
+							return
+						}
+						if lines++; lines < linesPerReq {
+							continue
+						}
+						lines = 0
+					}
+					if _, err := nc.Write(resp); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func benchClient(b *testing.B, zsolt bool, linesPerReq int, reply string) *Client {
+	c := New(benchServer(b, zsolt, linesPerReq, reply))
+	c.UseZsolt = zsolt
+	if err := c.Warmup(1); err != nil {
+		b.Fatal(err)
+	}
+	return c
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, zsolt := range []bool{false, true} {
+		name := "plain"
+		if zsolt {
+			name = "zsolt"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := benchClient(b, zsolt, 1, "END\r\n")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Get("0123456789abcdef", 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	for _, zsolt := range []bool{false, true} {
+		name := "plain"
+		if zsolt {
+			name = "zsolt"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := benchClient(b, zsolt, 2, "STORED\r\n")
+			item := &Item{Key: "0123456789abcdef", Value: make([]byte, 64)}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := c.Set(item); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
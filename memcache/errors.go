@@ -0,0 +1,128 @@
+package memcache
+
+import (
+	"bytes"
+	"errors"
+	"net"
+)
+
+var (
+	// ErrClientError is matched by errors.Is for every *ClientError.
+	ErrClientError = errors.New("memcache: client error")
+
+	// ErrUnknownCommand is matched by errors.Is for every
+	// *UnknownCommandError.
+	ErrUnknownCommand = errors.New("memcache: unknown command")
+)
+
+// ServerError is returned when a server answers a command with
+// SERVER_ERROR, e.g. because it is out of memory. errors.Is matches it
+// against ErrServerError.
+type ServerError struct {
+	Cmd  string   // command that failed, e.g. "set"
+	Addr net.Addr // server that answered
+	Msg  string   // message sent by the server
+}
+
+func (se *ServerError) Error() string {
+	return responseErrorString("server error", se.Cmd, se.Addr, se.Msg)
+}
+
+// Is reports whether target is ErrServerError.
+func (se *ServerError) Is(target error) bool {
+	return target == ErrServerError
+}
+
+// ClientError is returned when a server answers a command with
+// CLIENT_ERROR, e.g. when incrementing a value that isn't a number.
+// errors.Is matches it against ErrClientError.
+type ClientError struct {
+	Cmd  string
+	Addr net.Addr
+	Msg  string
+}
+
+func (ce *ClientError) Error() string {
+	return responseErrorString("client error", ce.Cmd, ce.Addr, ce.Msg)
+}
+
+// Is reports whether target is ErrClientError.
+func (ce *ClientError) Is(target error) bool {
+	return target == ErrClientError
+}
+
+// UnknownCommandError is returned when a server answers a command with
+// ERROR, meaning it doesn't support the command. errors.Is matches it
+// against ErrUnknownCommand.
+type UnknownCommandError struct {
+	Cmd  string
+	Addr net.Addr
+}
+
+func (ue *UnknownCommandError) Error() string {
+	return responseErrorString("unknown command", ue.Cmd, ue.Addr, "")
+}
+
+// Is reports whether target is ErrUnknownCommand.
+func (ue *UnknownCommandError) Is(target error) bool {
+	return target == ErrUnknownCommand
+}
+
+func responseErrorString(kind, cmd string, addr net.Addr, msg string) string {
+	s := "memcache: " + kind
+	if cmd != "" {
+		s += " in " + cmd
+	}
+	if addr != nil {
+		s += " from " + addr.String()
+	}
+	if msg != "" {
+		s += ": " + msg
+	}
+	return s
+}
+
+// responseError returns the error for an ERROR, CLIENT_ERROR or
+// SERVER_ERROR response line to cmd, or nil if line is none of them.
+// Leading padding of the Zsolt protocol is ignored.
+func responseError(cmd string, line []byte) error {
+	line = trimLine(line)
+	switch {
+	case bytes.HasPrefix(line, resultServerErrorPrefix):
+		return &ServerError{Cmd: cmd, Msg: string(line[len(resultServerErrorPrefix):])}
+	case bytes.HasPrefix(line, resultClientErrorPrefix):
+		return &ClientError{Cmd: cmd, Msg: string(line[len(resultClientErrorPrefix):])}
+	case bytes.Equal(line, resultError):
+		return &UnknownCommandError{Cmd: cmd}
+	}
+	return nil
+}
+
+// annotateError fills in the command and server address of a response
+// error where they aren't known yet, and returns err.
+func annotateError(err error, cmd string, addr net.Addr) error {
+	switch e := err.(type) {
+	case *ServerError:
+		if e.Cmd == "" {
+			e.Cmd = cmd
+		}
+		if e.Addr == nil {
+			e.Addr = addr
+		}
+	case *ClientError:
+		if e.Cmd == "" {
+			e.Cmd = cmd
+		}
+		if e.Addr == nil {
+			e.Addr = addr
+		}
+	case *UnknownCommandError:
+		if e.Cmd == "" {
+			e.Cmd = cmd
+		}
+		if e.Addr == nil {
+			e.Addr = addr
+		}
+	}
+	return err
+}
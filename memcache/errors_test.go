@@ -0,0 +1,175 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// errorHandler answers set with SERVER_ERROR, incr with CLIENT_ERROR,
+// get with SERVER_ERROR and everything else with ERROR.
+func errorHandler(line string, r *bufio.Reader, w *bufio.Writer) {
+	switch strings.Fields(line)[0] {
+	case "set":
+		r.ReadString('\n')
+		w.WriteString("SERVER_ERROR out of memory storing object\r\n")
+	case "incr":
+		w.WriteString("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	case "get":
+		w.WriteString("SERVER_ERROR busy\r\n")
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+}
+
+func TestServerErrorResponses(t *testing.T) {
+	s := newStubServer(t, errorHandler)
+	c := New(s.Addr())
+	c.Timeout = time.Second
+
+	err := c.Set(&Item{Key: "foo", Value: []byte("bar")})
+	var se *ServerError
+	if !errors.As(err, &se) {
+		t.Fatalf("Set: got %v, want *ServerError", err)
+	}
+	if !errors.Is(err, ErrServerError) {
+		t.Errorf("Set error %v doesn't match ErrServerError", err)
+	}
+	if se.Cmd != "set" || se.Addr == nil || se.Addr.String() != s.Addr() || se.Msg != "out of memory storing object" {
+		t.Errorf("Set error = %+v", se)
+	}
+
+	if _, err := c.Get("foo", 1); !errors.Is(err, ErrServerError) {
+		t.Fatalf("Get: got %v, want ErrServerError", err)
+	}
+
+	// SERVER_ERROR leaves the connection usable.
+	if n := s.Conns(); n != 1 {
+		t.Errorf("server accepted %d connections, want 1", n)
+	}
+
+	_, err = c.Increment("foo", 1)
+	var ce *ClientError
+	if !errors.As(err, &ce) || !errors.Is(err, ErrClientError) {
+		t.Fatalf("Increment: got %v, want *ClientError", err)
+	}
+	if ce.Cmd != "incr" || ce.Msg != "cannot increment or decrement non-numeric value" {
+		t.Errorf("Increment error = %+v", ce)
+	}
+
+	// CLIENT_ERROR drops the connection.
+	if _, err := c.Get("foo", 1); !errors.Is(err, ErrServerError) {
+		t.Fatalf("Get: got %v, want ErrServerError", err)
+	}
+	if n := s.Conns(); n != 2 {
+		t.Errorf("server accepted %d connections, want 2", n)
+	}
+
+	err = c.Touch("foo", 10)
+	var ue *UnknownCommandError
+	if !errors.As(err, &ue) || !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("Touch: got %v, want *UnknownCommandError", err)
+	}
+	if ue.Cmd != "touch" {
+		t.Errorf("Touch error = %+v", ue)
+	}
+
+	// ERROR drops the connection.
+	if _, err := c.Get("foo", 1); !errors.Is(err, ErrServerError) {
+		t.Fatalf("Get: got %v, want ErrServerError", err)
+	}
+	if n := s.Conns(); n != 3 {
+		t.Errorf("server accepted %d connections, want 3", n)
+	}
+}
+
+// TestClientErrorBadDataChunk checks that the ERROR memcached sends
+// after rejecting a data block with CLIENT_ERROR isn't taken for the
+// answer to the next request.
+func TestClientErrorBadDataChunk(t *testing.T) {
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		switch strings.Fields(line)[0] {
+		case "set":
+			r.ReadString('\n')
+			w.WriteString("CLIENT_ERROR bad data chunk\r\nERROR\r\n")
+		case "add":
+			r.ReadString('\n')
+			w.WriteString("STORED\r\n")
+		}
+	})
+	c := New(s.Addr())
+	c.Timeout = time.Second
+
+	if err := c.Set(&Item{Key: "k", Value: []byte("v")}); !errors.Is(err, ErrClientError) {
+		t.Fatalf("Set: got %v, want ErrClientError", err)
+	}
+	if err := c.Add(&Item{Key: "k", Value: []byte("v")}); err != nil {
+		t.Errorf("Add after CLIENT_ERROR: %v", err)
+	}
+	if n := s.Conns(); n != 2 {
+		t.Errorf("server accepted %d connections, want 2", n)
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		line string
+		want error
+	}{
+		{"SERVER_ERROR out of memory\r\n", ErrServerError},
+		{"\x00\x00CLIENT_ERROR bad data chunk\r\n", ErrClientError},
+		{"ERROR\r\n", ErrUnknownCommand},
+		{"END\r\n", nil},
+		{"STORED\r\n", nil},
+	}
+	for _, tt := range tests {
+		err := responseError("cmd", []byte(tt.line))
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("responseError(%q) = %v, want nil", tt.line, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("responseError(%q) = %v, want %v", tt.line, err, tt.want)
+		}
+	}
+}
+
+// TestServerErrorInScan checks that a SERVER_ERROR answering one step of
+// a scan doesn't leave the other steps' responses on the connection.
+func TestServerErrorInScan(t *testing.T) {
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		switch strings.Fields(line)[0] {
+		case "get":
+			w.WriteString("SERVER_ERROR busy\r\nEND\r\nEND\r\n")
+		case "ret":
+			r.ReadString('\n')
+			w.WriteString("END\r\nSERVER_ERROR busy\r\nEND\r\n")
+		case "set":
+			r.ReadString('\n')
+			w.WriteString("STORED\r\n")
+		}
+	})
+	c := New(s.Addr())
+	c.Timeout = time.Second
+
+	if _, err := c.Get("scan", 3); !errors.Is(err, ErrServerError) {
+		t.Fatalf("Get: got %v, want ErrServerError", err)
+	}
+	if err := c.Set(&Item{Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatalf("Set after Get: %v", err)
+	}
+	ritem := &Item{Key: "scan", Value: []byte(strings.Repeat("x", 32))}
+	if _, err := c.Ret(ritem, 3); !errors.Is(err, ErrServerError) {
+		t.Fatalf("Ret: got %v, want ErrServerError", err)
+	}
+	if err := c.Set(&Item{Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatalf("Set after Ret: %v", err)
+	}
+	if n := s.Conns(); n != 1 {
+		t.Errorf("server accepted %d connections, want 1", n)
+	}
+}
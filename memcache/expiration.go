@@ -0,0 +1,63 @@
+package memcache
+
+import (
+	"math"
+	"time"
+)
+
+// maxRelativeExpiration is the largest expiration, in seconds, that
+// memcached takes as relative to now; larger ones are Unix times.
+const maxRelativeExpiration = 30 * 24 * 60 * 60
+
+// TTL returns the Item.Expiration for an item that expires after d. The
+// expiration is relative if d is at most 30 days, and an absolute Unix
+// time otherwise. It is rounded up to whole seconds. A zero d means the
+// item doesn't expire, and a negative one that it expires immediately.
+func TTL(d time.Duration) int32 {
+	switch {
+	case d == 0:
+		return 0
+	case d < 0:
+		return -1
+	}
+	secs := (d + time.Second - 1) / time.Second
+	if secs <= maxRelativeExpiration {
+		return int32(secs)
+	}
+	unix := time.Now().Add(d).Unix()
+	if unix > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(unix)
+}
+
+// ExpireAt returns the Item.Expiration for an item that expires at t,
+// as TTL does for the time left until t. The zero Time means the item
+// doesn't expire, and a time in the past that it expires immediately.
+func ExpireAt(t time.Time) int32 {
+	if t.IsZero() {
+		return 0
+	}
+	d := time.Until(t)
+	if d <= 0 {
+		return -1
+	}
+	return TTL(d)
+}
+
+// SetWithTTL stores value under key, unconditionally, to expire after
+// ttl as TTL describes.
+func (c *Client) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	return c.Set(&Item{Key: key, Value: value, Expiration: TTL(ttl)})
+}
+
+// TouchWithTTL is Touch with the expiration given as for SetWithTTL.
+func (c *Client) TouchWithTTL(key string, ttl time.Duration) error {
+	return c.Touch(key, TTL(ttl))
+}
+
+// TouchUntil is Touch with the expiration given as a time, as for
+// ExpireAt.
+func (c *Client) TouchUntil(key string, t time.Time) error {
+	return c.Touch(key, ExpireAt(t))
+}
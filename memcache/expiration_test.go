@@ -0,0 +1,78 @@
+package memcache
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		d    time.Duration
+		want int32
+	}{
+		{0, 0},
+		{-time.Second, -1},
+		{time.Millisecond, 1},
+		{90 * time.Second, 90},
+		{1500 * time.Millisecond, 2},
+		{30 * day, maxRelativeExpiration},
+	}
+	for _, tt := range tests {
+		if got := TTL(tt.d); got != tt.want {
+			t.Errorf("TTL(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+
+	// Longer durations are absolute.
+	want := time.Now().Add(31 * day).Unix()
+	if got := int64(TTL(31 * day)); got < want-1 || got > want+1 {
+		t.Errorf("TTL(31 days) = %d, want about %d", got, want)
+	}
+	if got := TTL(30*day + time.Millisecond); got <= maxRelativeExpiration {
+		t.Errorf("TTL(30 days + 1ms) = %d, want an absolute time", got)
+	}
+	if got := TTL(200 * 365 * day); got != math.MaxInt32 {
+		t.Errorf("TTL(200 years) = %d, want %d", got, int32(math.MaxInt32))
+	}
+}
+
+func TestExpireAt(t *testing.T) {
+	if got := ExpireAt(time.Time{}); got != 0 {
+		t.Errorf("ExpireAt(zero) = %d, want 0", got)
+	}
+	if got := ExpireAt(time.Now().Add(-time.Hour)); got != -1 {
+		t.Errorf("ExpireAt(an hour ago) = %d, want -1", got)
+	}
+	if got := ExpireAt(time.Now().Add(time.Hour)); got < 3599 || got > 3600 {
+		t.Errorf("ExpireAt(in an hour) = %d, want 3600", got)
+	}
+	at := time.Now().Add(60 * 24 * time.Hour)
+	if got := int64(ExpireAt(at)); got < at.Unix()-1 || got > at.Unix()+1 {
+		t.Errorf("ExpireAt(in 60 days) = %d, want %d", got, at.Unix())
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		if err := c.SetWithTTL("k", []byte("v"), 2*time.Minute); err != nil {
+			t.Fatalf("SetWithTTL: %v", err)
+		}
+		if it, _ := fm.item("k"); it.exp != 120 || string(it.value) != "v" {
+			t.Errorf("stored item = %+v, want expiration 120", it)
+		}
+		if err := c.TouchWithTTL("k", time.Hour); err != nil {
+			t.Fatalf("TouchWithTTL: %v", err)
+		}
+		if it, _ := fm.item("k"); it.exp != 3600 {
+			t.Errorf("expiration = %d, want 3600", it.exp)
+		}
+		if err := c.TouchUntil("k", time.Time{}); err != nil {
+			t.Fatalf("TouchUntil: %v", err)
+		}
+		if it, _ := fm.item("k"); it.exp != 0 {
+			t.Errorf("expiration = %d, want 0", it.exp)
+		}
+	})
+}
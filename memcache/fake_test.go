@@ -0,0 +1,289 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeMemcache is an in-memory stand-in for a memcached server. It
+// understands enough of the text protocol to test the client against,
+// either over TCP or, through zsoltDialer, with the Zsolt protocol.
+type fakeMemcache struct {
+	mu    sync.Mutex
+	items map[string]*fakeItem
+	cas   uint64
+}
+
+type fakeItem struct {
+	value []byte
+	flags uint32
+	exp   int32
+	cas   uint64
+}
+
+func newFakeMemcache() *fakeMemcache {
+	return &fakeMemcache{items: make(map[string]*fakeItem)}
+}
+
+// storageCommand reports whether a request line of the given fields is
+// followed by a data block, and returns the block's length.
+func storageCommand(f []string) (size int, ok bool) {
+	switch f[0] {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		if len(f) < 5 {
+			return 0, false
+		}
+		n, err := strconv.Atoi(f[4])
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// handler serves the plain text protocol for a stubServer.
+func (fm *fakeMemcache) handler(line string, r *bufio.Reader, w *bufio.Writer) {
+	var data []byte
+	if size, ok := storageCommand(strings.Fields(line)); ok {
+		data = make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return
+		}
+		data = data[:size]
+	}
+	w.WriteString(fm.exec(line, data))
+}
+
+// zsoltHandler serves the Zsolt protocol for zsoltDialer. A frame may
+// hold several commands; the responses after the first are preceded by
+// 8 dummy bytes of their own, as if every command had its own frame.
+func (fm *fakeMemcache) zsoltHandler(payload []byte) []byte {
+	var reply []byte
+	for len(payload) > 0 {
+		i := bytes.Index(payload, crlf)
+		if i < 0 {
+			return append(reply, "ERROR\r\n"...)
+		}
+		line := string(payload[:i])
+		payload = payload[i+2:]
+		var data []byte
+		if size, ok := storageCommand(strings.Fields(line)); ok {
+			if len(payload) < size+2 {
+				return append(reply, "CLIENT_ERROR bad data chunk\r\n"...)
+			}
+			data, payload = payload[:size], payload[size+2:]
+		}
+		if reply != nil {
+			reply = append(reply, make([]byte, 8)...)
+		}
+		reply = append(reply, fm.exec(line, data)...)
+	}
+	return reply
+}
+
+func (fm *fakeMemcache) exec(line string, data []byte) string {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	f := strings.Fields(line)
+	if len(f) == 0 {
+		return "ERROR\r\n"
+	}
+	switch f[0] {
+	case "get", "gets":
+		return fm.values(f[1:], f[0] == "gets", nil)
+	case "gat", "gats":
+		exp, err := strconv.ParseInt(f[1], 10, 32)
+		if err != nil {
+			return "CLIENT_ERROR bad command line format\r\n"
+		}
+		e := int32(exp)
+		return fm.values(f[2:], f[0] == "gats", &e)
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return fm.store(f, data)
+	case "delete":
+		if _, ok := fm.items[f[1]]; !ok {
+			return "NOT_FOUND\r\n"
+		}
+		delete(fm.items, f[1])
+		return "DELETED\r\n"
+	case "touch":
+		it, ok := fm.items[f[1]]
+		if !ok {
+			return "NOT_FOUND\r\n"
+		}
+		exp, _ := strconv.ParseInt(f[2], 10, 32)
+		it.exp = int32(exp)
+		return "TOUCHED\r\n"
+	case "incr", "decr":
+		it, ok := fm.items[f[1]]
+		if !ok {
+			return "NOT_FOUND\r\n"
+		}
+		delta, _ := strconv.ParseUint(f[2], 10, 64)
+		v, err := fm.arith(it, f[0] == "incr", delta)
+		if err != nil {
+			return "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+		}
+		return strconv.FormatUint(v, 10) + "\r\n"
+	case "ma":
+		return fm.metaArithmetic(f[1], f[2:])
+	case "flush_all":
+		fm.items = make(map[string]*fakeItem)
+		return "OK\r\n"
+	}
+	return "ERROR\r\n"
+}
+
+func (fm *fakeMemcache) values(keys []string, withCAS bool, exp *int32) string {
+	var b strings.Builder
+	for _, key := range keys {
+		it, ok := fm.items[key]
+		if !ok {
+			continue
+		}
+		if exp != nil {
+			it.exp = *exp
+		}
+		fmt.Fprintf(&b, "VALUE %s %d %d", key, it.flags, len(it.value))
+		if withCAS {
+			fmt.Fprintf(&b, " %d", it.cas)
+		}
+		fmt.Fprintf(&b, "\r\n%s\r\n", it.value)
+	}
+	b.WriteString("END\r\n")
+	return b.String()
+}
+
+func (fm *fakeMemcache) store(f []string, data []byte) string {
+	if len(f) < 5 {
+		return "ERROR\r\n"
+	}
+	key := f[1]
+	flags, _ := strconv.ParseUint(f[2], 10, 32)
+	exp, _ := strconv.ParseInt(f[3], 10, 32)
+	old, exists := fm.items[key]
+	switch f[0] {
+	case "add":
+		if exists {
+			return "NOT_STORED\r\n"
+		}
+	case "replace":
+		if !exists {
+			return "NOT_STORED\r\n"
+		}
+	case "append", "prepend":
+		if !exists {
+			return "NOT_STORED\r\n"
+		}
+		if f[0] == "append" {
+			old.value = append(append([]byte(nil), old.value...), data...)
+		} else {
+			old.value = append(append([]byte(nil), data...), old.value...)
+		}
+		fm.cas++
+		old.cas = fm.cas
+		return "STORED\r\n"
+	case "cas":
+		if !exists {
+			return "NOT_FOUND\r\n"
+		}
+		if len(f) < 6 || f[5] != strconv.FormatUint(old.cas, 10) {
+			return "EXISTS\r\n"
+		}
+	}
+	fm.cas++
+	fm.items[key] = &fakeItem{
+		value: append([]byte(nil), data...),
+		flags: uint32(flags),
+		exp:   int32(exp),
+		cas:   fm.cas,
+	}
+	return "STORED\r\n"
+}
+
+func (fm *fakeMemcache) arith(it *fakeItem, incr bool, delta uint64) (uint64, error) {
+	v, err := strconv.ParseUint(string(it.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case incr:
+		v += delta
+	case delta > v:
+		v = 0
+	default:
+		v -= delta
+	}
+	it.value = []byte(strconv.FormatUint(v, 10))
+	return v, nil
+}
+
+// metaArithmetic implements the ma command with the flags v, M, N, J
+// and D.
+func (fm *fakeMemcache) metaArithmetic(key string, flags []string) string {
+	incr, vivify := true, false
+	var ttl int64
+	var initial, delta uint64 = 0, 1
+	for _, fl := range flags {
+		switch fl[0] {
+		case 'M':
+			incr = fl[1:] == "I" || fl[1:] == "+"
+		case 'N':
+			vivify = true
+			ttl, _ = strconv.ParseInt(fl[1:], 10, 32)
+		case 'J':
+			initial, _ = strconv.ParseUint(fl[1:], 10, 64)
+		case 'D':
+			delta, _ = strconv.ParseUint(fl[1:], 10, 64)
+		}
+	}
+	it, ok := fm.items[key]
+	var v uint64
+	switch {
+	case ok:
+		var err error
+		if v, err = fm.arith(it, incr, delta); err != nil {
+			return "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+		}
+	case vivify:
+		v = initial
+		fm.cas++
+		fm.items[key] = &fakeItem{value: []byte(strconv.FormatUint(v, 10)), exp: int32(ttl), cas: fm.cas}
+	default:
+		return "NF\r\n"
+	}
+	s := strconv.FormatUint(v, 10)
+	return fmt.Sprintf("VA %d\r\n%s\r\n", len(s), s)
+}
+
+// item returns a copy of the item stored under key.
+func (fm *fakeMemcache) item(key string) (fakeItem, bool) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	it, ok := fm.items[key]
+	if !ok {
+		return fakeItem{}, false
+	}
+	return *it, true
+}
+
+// forEachProtocol runs fn with a client talking to a fresh fakeMemcache,
+// once over the plain text protocol and once with the Zsolt protocol.
+func forEachProtocol(t *testing.T, fn func(t *testing.T, c *Client, fm *fakeMemcache)) {
+	t.Run("plain", func(t *testing.T) {
+		fm := newFakeMemcache()
+		s := newStubServer(t, fm.handler)
+		fn(t, New(s.Addr()), fm)
+	})
+	t.Run("zsolt", func(t *testing.T) {
+		fm := newFakeMemcache()
+		c := New("127.0.0.1:1")
+		c.UseZsolt = true
+		c.Dialer = zsoltDialer(fm.zsoltHandler)
+		fn(t, c, fm)
+	})
+}
@@ -0,0 +1,141 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MultiError is returned by requests that fan out to several servers,
+// such as GetMulti, FlushAll and Stats, when some of the servers
+// failed. It maps every failed server to its error; the results of the
+// other servers are returned alongside it. errors.Is and errors.As
+// look through all of its errors.
+type MultiError map[net.Addr]error
+
+func (me MultiError) Error() string {
+	addrs := me.addrs()
+	msgs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		msgs[i] = addr.String() + ": " + me[addr].Error()
+	}
+	return fmt.Sprintf("memcache: %d of the servers failed: %s", len(me), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the failed servers, ordered by address.
+func (me MultiError) Unwrap() []error {
+	addrs := me.addrs()
+	errs := make([]error, len(addrs))
+	for i, addr := range addrs {
+		errs[i] = me[addr]
+	}
+	return errs
+}
+
+func (me MultiError) addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(me))
+	for addr := range me {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].String() < addrs[j].String() })
+	return addrs
+}
+
+// servers returns every server of the client's selector.
+func (c *Client) servers() ([]net.Addr, error) {
+	var addrs []net.Addr
+	err := c.selector.Each(func(addr net.Addr) error {
+		addrs = append(addrs, addr)
+		return nil
+	})
+	return addrs, err
+}
+
+// fanOut calls fn for every address concurrently and collects the
+// failures in a MultiError. It returns nil if all calls succeeded.
+func fanOut(addrs []net.Addr, fn func(net.Addr) error) error {
+	var (
+		mu sync.Mutex
+		me MultiError
+		wg sync.WaitGroup
+	)
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr net.Addr) {
+			defer wg.Done()
+			if err := fn(addr); err != nil {
+				mu.Lock()
+				if me == nil {
+					me = make(MultiError)
+				}
+				me[addr] = err
+				mu.Unlock()
+			}
+		}(addr)
+	}
+	wg.Wait()
+	if me == nil {
+		return nil
+	}
+	return me
+}
+
+// Stats returns the general-purpose statistics of every server, by
+// name. If some servers fail, the statistics of the others are
+// returned along with a MultiError. A server that answers without any
+// statistics fails with ErrNoStats.
+func (c *Client) Stats() (map[net.Addr]map[string]string, error) {
+	addrs, err := c.servers()
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	stats := make(map[net.Addr]map[string]string, len(addrs))
+	err = fanOut(addrs, func(addr net.Addr) error {
+		s, err := c.statsFromAddr(addr)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		stats[addr] = s
+		mu.Unlock()
+		return nil
+	})
+	return stats, err
+}
+
+var resultStatPrefix = []byte("STAT ")
+
+func (c *Client) statsFromAddr(addr net.Addr) (map[string]string, error) {
+	stats := make(map[string]string)
+	err := c.withAddrRw(addr, func(rw *bufio.ReadWriter) error {
+		line, err := c.roundTrip(rw, []byte("stats\r\n"))
+		for ; ; line, err = rw.ReadSlice('\n') {
+			if err != nil {
+				return err
+			}
+			if err := responseError("stats", line); err != nil {
+				return err
+			}
+			if bytes.Equal(line, resultEnd) {
+				break
+			}
+			if !bytes.HasPrefix(line, resultStatPrefix) {
+				return fmt.Errorf("memcache: unexpected response line from stats: %q", string(line))
+			}
+			f := strings.SplitN(string(bytes.TrimRight(line[len(resultStatPrefix):], "\r\n")), " ", 2)
+			if len(f) == 2 {
+				stats[f[0]] = f[1]
+			}
+		}
+		if len(stats) == 0 {
+			return ErrNoStats
+		}
+		return nil
+	})
+	return stats, err
+}
@@ -0,0 +1,115 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func statsHandler(line string, r *bufio.Reader, w *bufio.Writer) {
+	switch line {
+	case "stats":
+		w.WriteString("STAT pid 42\r\nSTAT version 1.6.0\r\nEND\r\n")
+	case "flush_all":
+		w.WriteString("OK\r\n")
+	default:
+		endHandler(line, r, w)
+	}
+}
+
+func TestFanOutPartialFailure(t *testing.T) {
+	good := newStubServer(t, statsHandler)
+	bad := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		w.WriteString("SERVER_ERROR busy\r\n")
+	})
+	c := New(good.Addr(), bad.Addr())
+	c.Timeout = time.Second
+	goodAddr, badAddr := mustResolve(t, good.Addr()), mustResolve(t, bad.Addr())
+
+	stats, err := c.Stats()
+	var me MultiError
+	if !errors.As(err, &me) {
+		t.Fatalf("Stats: got %v, want MultiError", err)
+	}
+	if len(me) != 1 {
+		t.Fatalf("Stats: %d failed servers, want 1: %v", len(me), err)
+	}
+	for addr, err := range me {
+		if addr.String() != badAddr.String() || !errors.Is(err, ErrServerError) {
+			t.Errorf("Stats: server %v failed with %v", addr, err)
+		}
+	}
+	if !errors.Is(err, ErrServerError) {
+		t.Errorf("Stats error %v doesn't match ErrServerError", err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("Stats returned %d servers, want 1", len(stats))
+	}
+	for addr, s := range stats {
+		if addr.String() != goodAddr.String() || s["pid"] != "42" || s["version"] != "1.6.0" {
+			t.Errorf("Stats: %v => %v", addr, s)
+		}
+	}
+
+	err = c.FlushAll()
+	if !errors.As(err, &me) || len(me) != 1 {
+		t.Fatalf("FlushAll: got %v, want MultiError for one server", err)
+	}
+	if !strings.Contains(err.Error(), bad.Addr()) {
+		t.Errorf("FlushAll error %q doesn't name the failed server", err)
+	}
+	if _, err := c.GetMulti([]string{"a", "b", "c", "d", "e", "f"}); !errors.Is(err, ErrServerError) {
+		t.Errorf("GetMulti: got %v, want a MultiError matching ErrServerError", err)
+	}
+}
+
+func TestFanOutSuccess(t *testing.T) {
+	s1 := newStubServer(t, statsHandler)
+	s2 := newStubServer(t, statsHandler)
+	c := New(s1.Addr(), s2.Addr())
+	c.Timeout = time.Second
+	if err := c.FlushAll(); err != nil {
+		t.Fatalf("FlushAll: %v", err)
+	}
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Errorf("Stats returned %d servers, want 2", len(stats))
+	}
+}
+
+func TestStatsNoStats(t *testing.T) {
+	s := newStubServer(t, endHandler)
+	c := New(s.Addr())
+	if _, err := c.Stats(); !errors.Is(err, ErrNoStats) {
+		t.Errorf("Stats: got %v, want ErrNoStats", err)
+	}
+}
+
+func TestStatsZsolt(t *testing.T) {
+	c := New("127.0.0.1:1")
+	c.Timeout = time.Second
+	c.UseZsolt = true
+	c.Dialer = zsoltDialer(func(payload []byte) []byte {
+		if string(payload) != "stats\r\n" {
+			return []byte("ERROR\r\n")
+		}
+		return []byte("STAT pid 1\r\nSTAT uptime 2\r\nEND\r\n")
+	})
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	for _, s := range stats {
+		if s["pid"] != "1" || s["uptime"] != "2" {
+			t.Errorf("Stats = %v", s)
+		}
+	}
+	if len(stats) != 1 {
+		t.Errorf("Stats returned %d servers, want 1", len(stats))
+	}
+}
@@ -0,0 +1,117 @@
+package memcache
+
+import (
+	"bufio"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingServer is a stub server that answers flush_all with OK and
+// records the request lines it receives.
+type recordingServer struct {
+	*stubServer
+	mu    sync.Mutex
+	lines []string
+}
+
+func newRecordingServer(t *testing.T) *recordingServer {
+	rs := new(recordingServer)
+	rs.stubServer = newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		rs.mu.Lock()
+		rs.lines = append(rs.lines, line)
+		rs.mu.Unlock()
+		w.WriteString("OK\r\n")
+	})
+	return rs
+}
+
+func (rs *recordingServer) Lines() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.lines...)
+}
+
+func TestDeleteAllFlushesEveryServer(t *testing.T) {
+	s1, s2 := newRecordingServer(t), newRecordingServer(t)
+	c := New(s1.Addr(), s2.Addr())
+	c.Timeout = time.Second
+	if err := c.DeleteAll(); err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	for _, s := range []*recordingServer{s1, s2} {
+		if lines := s.Lines(); len(lines) != 1 || lines[0] != "flush_all" {
+			t.Errorf("server %s got %q, want [flush_all]", s.Addr(), lines)
+		}
+	}
+}
+
+func TestFlushAllWithOptions(t *testing.T) {
+	s1, s2 := newRecordingServer(t), newRecordingServer(t)
+	c := New(s1.Addr(), s2.Addr())
+	c.Timeout = time.Second
+
+	results, err := c.FlushAllWithOptions(FlushOptions{Delay: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("FlushAllWithOptions: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("got results for %d servers, want 2", len(results))
+	}
+	for addr, err := range results {
+		if err != nil {
+			t.Errorf("%v: %v", addr, err)
+		}
+	}
+
+	if _, err := c.FlushAllWithOptions(FlushOptions{Delay: 1500 * time.Millisecond, NoReply: true}); err != nil {
+		t.Fatalf("FlushAllWithOptions with NoReply: %v", err)
+	}
+	want := []string{"flush_all 10", "flush_all 1 noreply"}
+	for _, s := range []*recordingServer{s1, s2} {
+		deadline := time.Now().Add(time.Second)
+		for len(s.Lines()) < len(want) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		lines := s.Lines()
+		if len(lines) != len(want) || lines[0] != want[0] || lines[1] != want[1] {
+			t.Errorf("server %s got %q, want %q", s.Addr(), lines, want)
+		}
+	}
+}
+
+func TestFlushAllZsolt(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []string
+	c := New("127.0.0.1:1", "127.0.0.1:2")
+	c.UseZsolt = true
+	c.Dialer = zsoltDialer(func(payload []byte) []byte {
+		mu.Lock()
+		payloads = append(payloads, string(payload))
+		mu.Unlock()
+		return []byte("OK\r\n")
+	})
+	results, err := c.FlushAllWithOptions(FlushOptions{Delay: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("FlushAllWithOptions: %v", err)
+	}
+	for _, addr := range []string{"127.0.0.1:1", "127.0.0.1:2"} {
+		found := false
+		for a, err := range results {
+			if a.String() == addr {
+				found = true
+				if err != nil {
+					t.Errorf("%s: %v", addr, err)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("no result for %s", addr)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(payloads) != 2 || payloads[0] != "flush_all 5\r\n" || payloads[1] != "flush_all 5\r\n" {
+		t.Errorf("server got frames %q", payloads)
+	}
+}
@@ -0,0 +1,310 @@
+package memcache
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHedgePercentile is the latency percentile used when
+	// HedgePolicy.Percentile is zero.
+	DefaultHedgePercentile = 0.95
+
+	// DefaultHedgeDelay is the hedge delay used until enough latency
+	// samples have been observed, and when HedgePolicy.MinDelay is zero.
+	DefaultHedgeDelay = 2 * time.Millisecond
+
+	// hedgeWindow is the number of recent read latencies kept for
+	// computing the hedge delay.
+	hedgeWindow = 512
+
+	// hedgeMinSamples is the number of samples required before the
+	// observed percentile replaces MinDelay.
+	hedgeMinSamples = 32
+)
+
+// HedgePolicy configures hedged reads. When set on a Client, a Get or
+// Ret that hasn't been answered within the policy's delay is sent to a
+// second replica as well, and the first hit wins; a miss or an error
+// only wins once every attempt has answered. The losing request's
+// connection is closed rather than returned to the pool. Hedged reads
+// read the values, so that hits can be told apart from misses, and
+// return them.
+//
+// Hedging requires a ServerSelector that implements ReplicaSelector and
+// names at least two servers for the key; otherwise, as with the
+// selectors of this package, the policy has no effect.
+type HedgePolicy struct {
+	// Percentile is the percentile of recently observed read latencies
+	// after which the hedged request is sent, in (0, 1]. If zero,
+	// DefaultHedgePercentile is used.
+	Percentile float64
+
+	// MinDelay is the lower bound of the hedge delay. It is also the
+	// delay used until enough latencies have been observed. If zero,
+	// DefaultHedgeDelay is used.
+	MinDelay time.Duration
+}
+
+func (p *HedgePolicy) percentile() float64 {
+	if p.Percentile > 0 && p.Percentile <= 1 {
+		return p.Percentile
+	}
+	return DefaultHedgePercentile
+}
+
+func (p *HedgePolicy) minDelay() time.Duration {
+	if p.MinDelay > 0 {
+		return p.MinDelay
+	}
+	return DefaultHedgeDelay
+}
+
+// latencyWindow keeps the most recent read latencies in a ring buffer.
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples [hedgeWindow]time.Duration
+	n       int // number of valid samples
+	next    int // next slot to write
+}
+
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+	if w.n < len(w.samples) {
+		w.n++
+	}
+}
+
+// percentile returns the p-th percentile of the recorded latencies, and
+// false if too few samples have been recorded.
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mu.Lock()
+	if w.n < hedgeMinSamples {
+		w.mu.Unlock()
+		return 0, false
+	}
+	s := make([]time.Duration, w.n)
+	copy(s, w.samples[:w.n])
+	w.mu.Unlock()
+
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	i := int(p*float64(len(s))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(s) {
+		i = len(s) - 1
+	}
+	return s[i], true
+}
+
+func (c *Client) hedgeDelay() time.Duration {
+	d := c.Hedge.minDelay()
+	if p, ok := c.latencies.percentile(c.Hedge.percentile()); ok && p > d {
+		d = p
+	}
+	return d
+}
+
+// hedgeAddrs returns the servers a hedged read of key is sent to, and
+// false if the read should not be hedged.
+func (c *Client) hedgeAddrs(key string) ([]net.Addr, bool) {
+	if c.Hedge == nil || !legalKey(key) {
+		return nil, false
+	}
+	rs, ok := c.selector.(ReplicaSelector)
+	if !ok {
+		return nil, false
+	}
+	addrs, err := rs.PickReplicas(key, 2)
+	if err != nil || len(addrs) < 2 {
+		return nil, false
+	}
+	return addrs, true
+}
+
+// hedge tracks the connections of one hedged read and decides which
+// attempt wins.
+type hedge struct {
+	mu    sync.Mutex
+	done  bool
+	conns []*conn
+}
+
+// track registers cn with the hedge. It returns false if the read has
+// already been decided, in which case the caller must close cn.
+func (h *hedge) track(cn *conn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done {
+		return false
+	}
+	h.conns = append(h.conns, cn)
+	return true
+}
+
+// settle disposes of the connection of an attempt that finished with
+// err and reports whether the attempt won, and whether the read had
+// already been decided by another attempt. The first attempt to get a
+// hit wins and releases its connection; all other connections,
+// including those still waiting on a response, are closed so that no
+// unread bytes end up in the free pool. An attempt that got a miss or a
+// resumable error doesn't decide the read, and releases its connection
+// too.
+func (h *hedge) settle(cn *conn, hit bool, err error) (won, late bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done || (err != nil && !resumableError(err)) {
+		if cn != nil {
+			cn.close()
+		}
+		return false, h.done
+	}
+	if !hit {
+		for i, other := range h.conns {
+			if other == cn {
+				h.conns = append(h.conns[:i], h.conns[i+1:]...)
+				break
+			}
+		}
+		cn.condRelease(&err)
+		return false, false
+	}
+	h.done = true
+	for _, other := range h.conns {
+		if other != cn {
+			other.nc.Close()
+		}
+	}
+	cn.condRelease(&err)
+	return true, false
+}
+
+// errHedgeLost is observed for attempts cut off by a faster replica.
+var errHedgeLost = errors.New("memcache: hedged request lost")
+
+type hedgeResult struct {
+	item    *Item
+	err     error
+	won     bool
+	latency time.Duration
+}
+
+// hedgedRead runs fn against addrs[0], and against addrs[1] as well if
+// the first server hasn't answered within the hedge delay or failed.
+// The result of the first attempt to get a hit is returned. Otherwise,
+// once no attempt is in flight, a miss is returned, or else the last
+// error.
+func (c *Client) hedgedRead(addrs []net.Addr, fn func(*conn, func(*Item)) error) (*Item, error) {
+	h := new(hedge)
+	results := make(chan hedgeResult, len(addrs))
+	attempt := func(addr net.Addr) {
+		start := time.Now()
+		var item *Item
+		var cn *conn
+		err := c.allow(addr)
+		rejected := err != nil
+		if !rejected {
+			cn, err = c.getConn(addr)
+		}
+		if err == nil {
+			if !h.track(cn) {
+				cn.close()
+				results <- hedgeResult{err: err}
+				return
+			}
+			err = annotateError(fn(cn, func(it *Item) { item = it }), "", addr)
+		}
+		hit := err == nil && item != nil
+		won, late := h.settle(cn, hit, err)
+		switch {
+		case rejected:
+		case late:
+			// Attempts that lost the race were cut off by
+			// closing their connection and say nothing about
+			// the health of their server.
+			c.observe(addr, errHedgeLost, 0)
+		default:
+			c.observe(addr, err, time.Since(start))
+		}
+		results <- hedgeResult{item: item, err: err, won: won, latency: time.Since(start)}
+	}
+
+	go attempt(addrs[0])
+	launched, pending := 1, 1
+	timer := time.NewTimer(c.hedgeDelay())
+	defer timer.Stop()
+
+	var err error
+	missed := false
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if launched < len(addrs) {
+				go attempt(addrs[launched])
+				launched++
+				pending++
+			}
+		case r := <-results:
+			pending--
+			if r.won {
+				c.latencies.observe(r.latency)
+				return r.item, r.err
+			}
+			if r.err == nil {
+				missed = true
+			} else {
+				err = r.err
+			}
+			// Don't wait for the timer if the only attempt in
+			// flight has failed. A miss is final once no other
+			// attempt can turn it into a hit.
+			if pending == 0 && launched < len(addrs) && !missed {
+				go attempt(addrs[launched])
+				launched++
+				pending++
+			}
+		}
+	}
+	if missed {
+		return nil, nil
+	}
+	return nil, err
+}
+
+// getHedged is getFromConn for hedged reads. It reads the values, which
+// getFromConn skips, so that a hit can be told apart from a miss.
+func (c *Client) getHedged(cn *conn, key string, cb func(*Item), scancount int) error {
+	rb := getReqBuf()
+	defer putReqBuf(rb)
+	rb.line = appendGetLine(rb.line, []string{key})
+	if err := c.send(cn, rb, nil, false); err != nil {
+		return err
+	}
+	return c.readHedged(cn, "get", cb, scancount)
+}
+
+// retHedged is retFromConn for hedged reads, as getHedged is for
+// getFromConn.
+func (c *Client) retHedged(cn *conn, item *Item, cb func(*Item), scancount int) error {
+	rb := getReqBuf()
+	defer putReqBuf(rb)
+	rb.line = appendDataLine(rb.line, "ret", item.Key, len(item.Value))
+	if err := c.send(cn, rb, item.Value, true); err != nil {
+		return err
+	}
+	return c.readHedged(cn, "ret", cb, scancount)
+}
+
+func (c *Client) readHedged(cn *conn, cmd string, cb func(*Item), scancount int) error {
+	return readScan(scancount, func() error {
+		cn.extendReadDeadline()
+		return annotateError(c.readValues(cn.rw.Reader, cmd, cb), cmd, nil)
+	})
+}
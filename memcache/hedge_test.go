@@ -0,0 +1,145 @@
+package memcache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replicatedServers is a ReplicaSelector for servers that replicate
+// each other's writes: every key is on all of them, the first one
+// preferred.
+type replicatedServers []net.Addr
+
+func newReplicatedServers(t *testing.T, servers ...string) replicatedServers {
+	var rs replicatedServers
+	for _, s := range servers {
+		rs = append(rs, mustResolve(t, s))
+	}
+	return rs
+}
+
+func (rs replicatedServers) PickServer(key string) (net.Addr, error) {
+	return rs[0], nil
+}
+
+func (rs replicatedServers) PickReplicas(key string, n int) ([]net.Addr, error) {
+	return rs[:min(n, len(rs))], nil
+}
+
+func (rs replicatedServers) Each(f func(net.Addr) error) error {
+	for _, a := range rs {
+		if err := f(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valueHandler answers every get with value, after waiting for delay.
+func valueHandler(value string, delay time.Duration) func(string, *bufio.Reader, *bufio.Writer) {
+	return func(line string, r *bufio.Reader, w *bufio.Writer) {
+		time.Sleep(delay)
+		fmt.Fprintf(w, "VALUE %s 0 %d\r\n%s\r\nEND\r\n", strings.Fields(line)[1], len(value), value)
+	}
+}
+
+func TestHedgedGet(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		<-release
+		endHandler(line, r, w)
+	})
+	fast := newStubServer(t, valueHandler("fast", 0))
+
+	c := NewFromSelector(newReplicatedServers(t, slow.Addr(), fast.Addr()))
+	c.Timeout = time.Second
+	c.Hedge = &HedgePolicy{MinDelay: 5 * time.Millisecond}
+
+	start := time.Now()
+	it, err := c.Get("k", 1)
+	if err != nil || it == nil || string(it.Value) != "fast" {
+		t.Fatalf("Get = %+v, %v; want the fast replica's value", it, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("hedged Get took %v; want it answered by the fast replica", d)
+	}
+
+	slowAddr, fastAddr := mustResolve(t, slow.Addr()), mustResolve(t, fast.Addr())
+	if _, ok := c.pool(slowAddr).get(); ok {
+		t.Errorf("losing connection to slow server was returned to the pool")
+	}
+	if _, ok := c.pool(fastAddr).get(); !ok {
+		t.Errorf("winning connection to fast server was not returned to the pool")
+	}
+}
+
+// TestHedgedGetMissWaits checks that a replica's fast miss doesn't win
+// over the value the primary returns later.
+func TestHedgedGetMissWaits(t *testing.T) {
+	primary := newStubServer(t, valueHandler("primary", 50*time.Millisecond))
+	replica := newStubServer(t, endHandler)
+
+	c := NewFromSelector(newReplicatedServers(t, primary.Addr(), replica.Addr()))
+	c.Timeout = time.Second
+	c.Hedge = &HedgePolicy{MinDelay: 5 * time.Millisecond}
+
+	it, err := c.Get("k", 1)
+	if err != nil || it == nil || string(it.Value) != "primary" {
+		t.Fatalf("Get = %+v, %v; want the primary's value", it, err)
+	}
+	// The replica's connection was clean and went back to the pool.
+	if _, ok := c.pool(mustResolve(t, replica.Addr())).get(); !ok {
+		t.Errorf("connection to the missing replica was not returned to the pool")
+	}
+}
+
+func TestHedgedGetFailover(t *testing.T) {
+	dead := newStubServer(t, endHandler)
+	dead.ln.Close()
+	live := newStubServer(t, endHandler)
+
+	c := NewFromSelector(newReplicatedServers(t, dead.Addr(), live.Addr()))
+	c.Hedge = &HedgePolicy{MinDelay: time.Hour}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if it, err := c.Get(k, 1); err != nil || it != nil {
+			t.Errorf("Get(%q) = %+v, %v; want a miss", k, it, err)
+		}
+	}
+}
+
+func TestHedgeNeedsReplicas(t *testing.T) {
+	c := New("127.0.0.1:1", "127.0.0.1:2")
+	c.Hedge = &HedgePolicy{}
+	if _, ok := c.hedgeAddrs("k"); ok {
+		t.Errorf("reads are hedged across a ServerList, whose servers don't replicate writes")
+	}
+}
+
+func TestLatencyWindowPercentile(t *testing.T) {
+	var w latencyWindow
+	if _, ok := w.percentile(0.5); ok {
+		t.Fatal("percentile of an empty window should not be available")
+	}
+	for i := 1; i <= 100; i++ {
+		w.observe(time.Duration(i) * time.Millisecond)
+	}
+	if got, _ := w.percentile(0.95); got != 95*time.Millisecond {
+		t.Errorf("p95 = %v, want 95ms", got)
+	}
+	if got, _ := w.percentile(1); got != 100*time.Millisecond {
+		t.Errorf("p100 = %v, want 100ms", got)
+	}
+}
+
+func mustResolve(t *testing.T, addr string) net.Addr {
+	a, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
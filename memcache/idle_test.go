@@ -0,0 +1,84 @@
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnMaxLifetime(t *testing.T) {
+	s := newStubServer(t, endHandler)
+	c := New(s.Addr())
+	c.ConnMaxLifetime = 20 * time.Millisecond
+
+	if _, err := c.Get("foo", 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := c.Get("foo", 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Conns(); n != 2 {
+		t.Errorf("server accepted %d connections, want 2", n)
+	}
+}
+
+func TestIdleConnReaper(t *testing.T) {
+	s := newStubServer(t, endHandler)
+	c := New(s.Addr())
+	c.ConnMaxIdleTime = 10 * time.Millisecond
+
+	if _, err := c.Get("foo", 1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		p := c.pool(s.ln.Addr())
+		p.mu.Lock()
+		idle, open := len(p.free), p.numOpen
+		p.mu.Unlock()
+		c.lk.Lock()
+		reaping := c.reaping
+		c.lk.Unlock()
+		if idle == 0 && open == 0 && !reaping {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("idle connection not reaped: %d idle, %d open", idle, open)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCheckConnLiveness(t *testing.T) {
+	// The server answers a single request per connection and then
+	// hangs up, like an FPGA being reset.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("skipping test; can't listen on loopback: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			bufio.NewReader(nc).ReadString('\n')
+			nc.Write([]byte("END\r\n"))
+			nc.Close()
+		}
+	}()
+
+	c := New(ln.Addr().String())
+	c.CheckConnLiveness = true
+	c.Timeout = time.Second
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("foo", 1); err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+		// Give the server's FIN time to arrive.
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,80 @@
+package memcache
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// maxHashKeyLen is the length of the longest key HashKey maps to.
+var maxHashKeyLen = base64.RawURLEncoding.EncodedLen(sha256.Size)
+
+// HashKey returns a KeyTransform that maps keys to the first n
+// characters of the unpadded URL-safe base64 encoding of their SHA-256
+// digest, which holds 6 bits of the digest per character. n is at most
+// 43; e.g. HashKey(DefaultFPGAKeyLen) maps keys to the FPGA's key width.
+func HashKey(n int) func(key string) string {
+	if n <= 0 || n > maxHashKeyLen {
+		panic("memcache: HashKey length out of range")
+	}
+	return func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		var b [43]byte
+		base64.RawURLEncoding.Encode(b[:], sum[:])
+		return string(b[:n])
+	}
+}
+
+// serverKey returns the key sent to the servers for key.
+func (c *Client) serverKey(key string) string {
+	if c.KeyTransform == nil {
+		return key
+	}
+	return c.KeyTransform(key)
+}
+
+// serverKeys returns the keys sent to the servers for keys.
+func (c *Client) serverKeys(keys []string) []string {
+	if c.KeyTransform == nil {
+		return keys
+	}
+	skeys := make([]string, len(keys))
+	for i, key := range keys {
+		skeys[i] = c.KeyTransform(key)
+	}
+	return skeys
+}
+
+// serverItem returns item, or a copy of it with the key sent to the
+// servers if the client transforms keys.
+func (c *Client) serverItem(item *Item) *Item {
+	if c.KeyTransform == nil {
+		return item
+	}
+	it := *item
+	it.Key = c.KeyTransform(item.Key)
+	return &it
+}
+
+// clientItem gives item, read back from a server, the key the caller
+// asked for.
+func clientItem(item *Item, key string) *Item {
+	if item != nil {
+		item.Key = key
+	}
+	return item
+}
+
+// clientResults rekeys results, keyed by the keys sent to the servers
+// for keys, by keys.
+func (c *Client) clientResults(results map[string]error, keys []string) map[string]error {
+	if c.KeyTransform == nil || results == nil {
+		return results
+	}
+	m := make(map[string]error, len(results))
+	for _, key := range keys {
+		if err, ok := results[c.KeyTransform(key)]; ok {
+			m[key] = err
+		}
+	}
+	return m
+}
@@ -0,0 +1,65 @@
+package memcache
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashKey(t *testing.T) {
+	h := HashKey(DefaultFPGAKeyLen)
+	a, b := h("https://example.com/a b"), h("https://example.com/a c")
+	if len(a) != DefaultFPGAKeyLen || !legalKey(a) {
+		t.Errorf("HashKey(16) = %q, want a legal 16-byte key", a)
+	}
+	if a == b {
+		t.Errorf("different keys hash to %q", a)
+	}
+	if h("https://example.com/a b") != a {
+		t.Errorf("HashKey isn't deterministic")
+	}
+	if n := len(HashKey(43)(strings.Repeat("x", 1000))); n != 43 {
+		t.Errorf("HashKey(43) length = %d", n)
+	}
+}
+
+func TestKeyTransform(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		c.KeyTransform = HashKey(DefaultFPGAKeyLen)
+		key := "https://example.com/some page?q=" + strings.Repeat("x", 300)
+		item := &Item{Key: key, Value: []byte("v")}
+		if err := c.Set(item); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if item.Key != key {
+			t.Errorf("Set changed the caller's key to %q", item.Key)
+		}
+		if _, ok := fm.item(c.KeyTransform(key)); !ok {
+			t.Errorf("item wasn't stored under the transformed key")
+		}
+		it, err := c.GetInto(key, nil)
+		if err != nil || it.Key != key || string(it.Value) != "v" {
+			t.Fatalf("GetInto = %+v, %v", it, err)
+		}
+		if it, err = c.GetAndTouch(key, 10); err != nil || it.Key != key {
+			t.Errorf("GetAndTouch = %+v, %v", it, err)
+		}
+		if _, err := c.Increment(key, 1); err == nil {
+			t.Errorf("Increment of non-numeric value succeeded")
+		}
+
+		results, err := c.SetMulti([]*Item{{Key: "a b", Value: []byte("1")}, {Key: "c d", Value: []byte("2")}})
+		if err != nil || len(results) != 2 || results["a b"] != nil || results["c d"] != nil {
+			t.Errorf("SetMulti = %v, %v", results, err)
+		}
+		results, err = c.DeleteMulti([]string{"a b", "e f"})
+		if err != nil || results["a b"] != nil || results["e f"] != ErrCacheMiss {
+			t.Errorf("DeleteMulti = %v, %v", results, err)
+		}
+		if _, err := c.GetInto("a b", nil); err != ErrCacheMiss {
+			t.Errorf("GetInto after DeleteMulti: got %v, want ErrCacheMiss", err)
+		}
+		if it, err := c.GetInto("c d", nil); err != nil || it.Key != "c d" {
+			t.Errorf("GetInto = %+v, %v", it, err)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// DefaultFPGAKeyLen is the key length the FPGA's hash table expects.
+	DefaultFPGAKeyLen = 16
+
+	// DefaultFPGABlockSize is the size of the FPGA's memory blocks.
+	// Every item, header included, fills a whole number of them.
+	DefaultFPGABlockSize = 64
+
+	// DefaultFPGAHeaderLen is the length of the header the FPGA stores
+	// in front of every value.
+	DefaultFPGAHeaderLen = 8
+
+	// jsonPrefixLen is the length of the prefix SetJSON puts in front
+	// of values.
+	jsonPrefixLen = 2
+)
+
+// ErrLayout is matched by errors.Is for every *LayoutError.
+var ErrLayout = errors.New("memcache: item doesn't fit the FPGA layout")
+
+// LayoutError is returned when an item doesn't fit the client's
+// FPGALayout, so that storing it would corrupt the FPGA's hash table.
+// errors.Is matches it against ErrLayout.
+type LayoutError struct {
+	Key    string
+	Reason string
+}
+
+func (le *LayoutError) Error() string {
+	return fmt.Sprintf("memcache: item %q doesn't fit the FPGA layout: %s", le.Key, le.Reason)
+}
+
+// Is reports whether target is ErrLayout.
+func (le *LayoutError) Is(target error) bool {
+	return target == ErrLayout
+}
+
+// FPGALayout describes how the FPGA lays out items in its memory. Items
+// that don't fit are rejected with a *LayoutError before they are sent.
+//
+// A value, together with the header the FPGA puts in front of it, must
+// fill a whole number of blocks: with the defaults, values are 56, 120,
+// 184, ... bytes long. Values appended or prepended to an item must fill
+// whole blocks themselves, so that the item stays aligned.
+type FPGALayout struct {
+	// KeyLen is the required length of keys. If zero,
+	// DefaultFPGAKeyLen is used; if negative, keys of any length are
+	// accepted.
+	KeyLen int
+
+	// BlockSize is the size of the FPGA's memory blocks. If zero,
+	// DefaultFPGABlockSize is used.
+	BlockSize int
+
+	// HeaderLen is the length of the header stored in front of every
+	// value. If zero, DefaultFPGAHeaderLen is used.
+	HeaderLen int
+
+	// JSONPrefix requires values to start with the 2-byte little-endian
+	// length prefix SetJSON writes, which counts as part of the value.
+	JSONPrefix bool
+
+	// Pad pads values with zeros up to the next block boundary instead
+	// of rejecting them. With JSONPrefix, the padding is stripped from
+	// the values read back; the prefix is kept.
+	Pad bool
+}
+
+func (l *FPGALayout) keyLen() int {
+	if l.KeyLen != 0 {
+		return l.KeyLen
+	}
+	return DefaultFPGAKeyLen
+}
+
+func (l *FPGALayout) blockSize() int {
+	if l.BlockSize > 0 {
+		return l.BlockSize
+	}
+	return DefaultFPGABlockSize
+}
+
+func (l *FPGALayout) headerLen() int {
+	if l.HeaderLen > 0 {
+		return l.HeaderLen
+	}
+	return DefaultFPGAHeaderLen
+}
+
+// fit checks item, to be stored with the storage command verb, against
+// the layout. It returns item, or a padded copy of it if l.Pad is set.
+func (l *FPGALayout) fit(verb string, item *Item) (*Item, error) {
+	size := len(item.Value)
+	if l.JSONPrefix && verb != "append" && verb != "prepend" {
+		if size < jsonPrefixLen {
+			return nil, &LayoutError{Key: item.Key, Reason: fmt.Sprintf("value of %d bytes has no JSON prefix", size)}
+		}
+		if n := int(binary.LittleEndian.Uint16(item.Value)); jsonPrefixLen+n > size {
+			return nil, &LayoutError{Key: item.Key,
+				Reason: fmt.Sprintf("JSON prefix declares %d bytes, value has only %d", n, size-jsonPrefixLen)}
+		}
+	}
+	pad, err := l.pad(verb, item.Key, size)
+	if err != nil || pad == 0 {
+		return item, err
+	}
+	padded := *item
+	padded.Value = make([]byte, size+pad)
+	copy(padded.Value, item.Value)
+	return &padded, nil
+}
+
+// pad checks the key and size of a value, to be stored with the storage
+// command verb, against the layout, leaving out the JSON prefix. It
+// returns the number of zeros the value must be padded with.
+func (l *FPGALayout) pad(verb, key string, size int) (int, error) {
+	fail := func(format string, args ...interface{}) (int, error) {
+		return 0, &LayoutError{Key: key, Reason: fmt.Sprintf(format, args...)}
+	}
+	if n := l.keyLen(); n > 0 && len(key) != n {
+		return fail("key is %d bytes long, want %d", len(key), n)
+	}
+	block := l.blockSize()
+	if verb == "append" || verb == "prepend" {
+		if size%block != 0 {
+			return fail("%s of %d bytes isn't a multiple of the %d-byte block", verb, size, block)
+		}
+		return 0, nil
+	}
+	pad := (block - (size+l.headerLen())%block) % block
+	if pad != 0 && !l.Pad {
+		return fail("value of %d bytes plus %d-byte header isn't a multiple of the %d-byte block",
+			size, l.headerLen(), block)
+	}
+	return pad, nil
+}
+
+// alignDown returns the largest value size, at most n, that fills whole
+// blocks with the header, and at least one block.
+func (l *FPGALayout) alignDown(n int) int {
+	block, header := l.blockSize(), l.headerLen()
+	n = (n+header)/block*block - header
+	for n <= 0 {
+		n += block
+	}
+	return n
+}
+
+// strip removes the padding added by fit from a value read back.
+func (l *FPGALayout) strip(value []byte) []byte {
+	if !l.Pad || !l.JSONPrefix || len(value) < jsonPrefixLen {
+		return value
+	}
+	if n := jsonPrefixLen + int(binary.LittleEndian.Uint16(value)); n <= len(value) {
+		return value[:n]
+	}
+	return value
+}
+
+// fitLayout checks item against the client's Layout, if any. See
+// FPGALayout.fit.
+func (c *Client) fitLayout(verb string, item *Item) (*Item, error) {
+	if c.Layout == nil {
+		return item, nil
+	}
+	return c.Layout.fit(verb, item)
+}
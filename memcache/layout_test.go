@@ -0,0 +1,81 @@
+package memcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+const layoutKey = "0123456789abcdef"
+
+func TestLayoutValidation(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		c.Layout = &FPGALayout{}
+		tests := []struct {
+			verb  string
+			item  *Item
+			valid bool
+		}{
+			{"set", &Item{Key: layoutKey, Value: make([]byte, 56)}, true},
+			{"set", &Item{Key: layoutKey, Value: make([]byte, 120)}, true},
+			{"set", &Item{Key: layoutKey, Value: make([]byte, 64)}, false},
+			{"set", &Item{Key: "short", Value: make([]byte, 56)}, false},
+			{"append", &Item{Key: layoutKey, Value: make([]byte, 64)}, true},
+			{"append", &Item{Key: layoutKey, Value: make([]byte, 56)}, false},
+		}
+		for _, tt := range tests {
+			var err error
+			if tt.verb == "append" {
+				err = c.Append(tt.item)
+			} else {
+				err = c.Set(tt.item)
+			}
+			if got := !errors.Is(err, ErrLayout); got != tt.valid {
+				t.Errorf("%s of %d-byte key, %d-byte value: err = %v", tt.verb, len(tt.item.Key), len(tt.item.Value), err)
+			}
+		}
+		if _, ok := fm.item("short"); ok {
+			t.Errorf("item with invalid key was stored")
+		}
+
+		_, err := c.SetMulti([]*Item{
+			{Key: layoutKey, Value: make([]byte, 56)},
+			{Key: "fedcba9876543210", Value: make([]byte, 10)},
+		})
+		var le *LayoutError
+		if !errors.As(err, &le) || le.Key != "fedcba9876543210" {
+			t.Errorf("SetMulti with misaligned value: got %v, want *LayoutError", err)
+		}
+	})
+}
+
+func TestLayoutPadding(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		c.Layout = &FPGALayout{JSONPrefix: true, Pad: true}
+		item := &Item{Key: layoutKey, Value: []byte("hello")}
+		if err := c.SetJSON(item); err != nil {
+			t.Fatalf("SetJSON: %v", err)
+		}
+		stored, _ := fm.item(layoutKey)
+		if len(stored.value) != 56 {
+			t.Errorf("stored value is %d bytes long, want 56", len(stored.value))
+		}
+		if len(item.Value) != 7 {
+			t.Errorf("caller's value was padded to %d bytes", len(item.Value))
+		}
+		it, err := c.GetInto(layoutKey, nil)
+		if err != nil {
+			t.Fatalf("GetInto: %v", err)
+		}
+		if want := append([]byte{5, 0}, "hello"...); !bytes.Equal(it.Value, want) {
+			t.Errorf("GetInto value = %q, want %q", it.Value, want)
+		}
+
+		bad := make([]byte, 56)
+		binary.LittleEndian.PutUint16(bad, 60)
+		if err := c.Set(&Item{Key: layoutKey, Value: bad}); !errors.Is(err, ErrLayout) {
+			t.Errorf("Set with overlong JSON prefix: got %v, want ErrLayout", err)
+		}
+	})
+}
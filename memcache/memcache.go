@@ -20,6 +20,7 @@ package memcache
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -27,6 +28,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
    "encoding/binary"
 )
@@ -48,7 +50,8 @@ var (
 	// CompareAndSwap) failed because the condition was not satisfied.
 	ErrNotStored = errors.New("memcache: item not stored")
 
-	// ErrServer means that a server error occurred.
+	// ErrServer means that a server error occurred. It is matched by
+	// errors.Is for every *ServerError.
 	ErrServerError = errors.New("memcache: server error")
 
 	// ErrNoStats means that no statistics were available.
@@ -61,6 +64,10 @@ var (
 
 	// ErrNoServers is returned when no servers are configured or available.
 	ErrNoServers = errors.New("memcache: no servers configured or available")
+
+	// ErrClientClosed is returned by requests on a Client that has been
+	// closed.
+	ErrClientClosed = errors.New("memcache: client closed")
 )
 
 
@@ -80,12 +87,50 @@ const buffered = 8 // arbitrary buffered channel size, for readability
 // This is used to determine whether or not a server connection should
 // be re-used or not. If an error occurs, by default we don't reuse the
 // connection, unless it was just a cache error.
+//
+// A SERVER_ERROR response is a complete answer to a request the server
+// has consumed, so the connection stays usable. After CLIENT_ERROR, e.g.
+// "bad data chunk", the server may not have consumed the request's data
+// block, and then either answers it with ERROR or closes the
+// connection; an ERROR response means the server didn't know the
+// command and may have taken its data block for further commands.
+// Either way the connection is dropped.
 func resumableError(err error) bool {
 	switch err {
-	case ErrCacheMiss, ErrCASConflict, ErrNotStored, ErrMalformedKey:
+	case ErrCacheMiss, ErrCASConflict, ErrNotStored, ErrMalformedKey, ErrFrameTooLarge:
 		return true
 	}
-	return false
+	_, ok := err.(*ServerError)
+	return ok
+}
+
+// answeredError reports whether err, which may be nil, is the server's
+// answer to a request rather than a failure to get one: a server that
+// answers CLIENT_ERROR is healthy, even if the connection is dropped.
+func answeredError(err error) bool {
+	if err == nil || resumableError(err) {
+		return true
+	}
+	_, ok := err.(*ClientError)
+	return ok
+}
+
+// readScan reads the n responses to a scanning request with read. A
+// response that is a cache error, such as SERVER_ERROR, doesn't end the
+// scan: the remaining responses are read all the same, so that the
+// connection can be reused, and the first such error is returned.
+func readScan(n int, read func() error) error {
+	var first error
+	for i := 0; i < n; i++ {
+		err := read()
+		if err != nil && !resumableError(err) {
+			return err
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
 }
 
 func legalKey(key string) bool {
@@ -113,7 +158,9 @@ var (
 	resultOk        = []byte("OK\r\n")
 	resultTouched   = []byte("TOUCHED\r\n")
 
+	resultError             = []byte("ERROR")
 	resultClientErrorPrefix = []byte("CLIENT_ERROR ")
+	resultServerErrorPrefix = []byte("SERVER_ERROR ")
 )
 
 // New returns a memcache client using the provided server(s)
@@ -137,6 +184,15 @@ type Client struct {
 	// If zero, DefaultTimeout is used.
 	Timeout time.Duration
 
+	// DialTimeout, ReadTimeout and WriteTimeout override Timeout for
+	// connecting, for reading a response and for writing a request.
+	// The read deadline is extended for every response of a scan, so
+	// ReadTimeout bounds the wait for each of them rather than for the
+	// whole scan. If zero, Timeout is used.
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
    // MaxIdleConns specifies the maximum number of idle connections that will
    // be maintained per address. If less than one, DefaultMaxIdleConns will be
    // used.
@@ -145,14 +201,96 @@ type Client struct {
    // be set to a number higher than your peak parallel requests.
    MaxIdleConns int
 
+	// MaxOpenConns limits the number of open connections per address,
+	// idle or in use. If zero, there is no limit. When the limit is
+	// reached, requests wait in FIFO order for a connection to be
+	// released, for at most Timeout, and fail with a
+	// *PoolExhaustedError if none is. Only Timeout bounds the wait;
+	// the client's methods take no context to cancel it.
+	MaxOpenConns int
+
+	// ConnMaxIdleTime is the maximum time a connection may sit idle in
+	// the pool. If zero, idle connections are kept until they fail.
+	ConnMaxIdleTime time.Duration
+
+	// ConnMaxLifetime is the maximum time a connection may be reused
+	// after it was dialed. If zero, connections are reused regardless
+	// of their age.
+	ConnMaxLifetime time.Duration
+
+	// CheckConnLiveness enables a cheap, non-blocking check when an idle
+	// connection is taken from the pool, so that connections closed by
+	// the server, for instance after an FPGA reset, are dropped before
+	// a request is sent on them. It is only supported on unix systems.
+	CheckConnLiveness bool
+
+	// Dialer, if non-nil, opens connections to servers in place of a
+	// net.Dialer. It can return any net.Conn, for instance an in-memory
+	// net.Pipe or a wrapper that injects faults. The context carries the
+	// connect timeout.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TCP, if non-nil, sets socket options on new TCP connections. If
+	// nil, Go's defaults are kept.
+	TCP *TCPOptions
+
    // use Zsolt's protocol
    UseZsolt bool
 
+	// Hedge enables hedged reads for Get and Ret. If nil, every read
+	// goes to a single server.
+	Hedge *HedgePolicy
+
+	// Retry, if non-nil, retries requests that fail with a network
+	// error. If nil, errors are returned to the caller right away.
+	Retry *RetryPolicy
+
+	// Breaker, if non-nil, gives every server a circuit breaker, so
+	// that requests to a failing server are rejected right away with a
+	// *CircuitOpenError instead of waiting for Timeout.
+	Breaker *BreakerPolicy
+
+	// Coalesce, if non-nil, lets concurrent requests to the same server
+	// share Zsolt frames. It has no effect unless UseZsolt is set.
+	Coalesce *CoalescePolicy
+
+	// ChunkSize is the largest value SetStream stores under a single
+	// key; larger values are split into chunks of this size. If zero,
+	// DefaultChunkSize is used. With UseZsolt, chunks are further
+	// limited to what fits in a frame, and with a Layout, rounded down
+	// to whole blocks.
+	ChunkSize int
+
+	// Layout, if non-nil, checks items against the FPGA's item layout
+	// before they are stored, and may pad their values.
+	Layout *FPGALayout
+
+	// KeyTransform, if non-nil, maps the keys given to the client to
+	// the keys sent to the servers, e.g. to hash keys that are too long,
+	// or not legal, into legal ones with HashKey. The items returned
+	// keep the keys the caller asked for. Keys are only checked once
+	// transformed.
+	KeyTransform func(key string) string
+
+	// NearCache, if non-nil, keeps the items got with Get in an
+	// in-process LRU cache in front of the servers.
+	NearCache *NearCachePolicy
 
 	selector ServerSelector
 
-	lk       sync.Mutex
-	freeconn map[string][]*conn
+	latencies latencyWindow
+	metrics   clientMetrics
+	near      nearCache
+
+	pools sync.Map // net.Addr -> *addrPool
+
+	lk          sync.Mutex
+	poolsByName map[string]*addrPool
+	reaping     bool       // whether reapConns is running
+	drained     *sync.Cond // signaled when the last request ends after Close
+
+	closed   atomic.Bool
+	inflight atomic.Int64 // connections checked out of the pool
 }
 
 // Item is an item to be got or stored in a memcached server.
@@ -169,7 +307,8 @@ type Item struct {
 
 	// Expiration is the cache expiration time, in seconds: either a relative
 	// time from now (up to 1 month), or an absolute Unix epoch time.
-	// Zero means the Item has no expiration time.
+	// Zero means the Item has no expiration time. TTL and ExpireAt
+	// compute it from a time.Duration or a time.Time.
 	Expiration int32
 
 	// Compare and swap ID.
@@ -182,15 +321,72 @@ type conn struct {
 	rw   *bufio.ReadWriter
 	addr net.Addr
 	c    *Client
+	pool *addrPool
+
+	createdAt time.Time
+	idleSince time.Time // when the connection was last put in the pool
+	inUse     bool      // checked out by a request
+
+	closeOnce sync.Once
 }
 
 // release returns this connection back to the client's free pool
 func (cn *conn) release() {
-	cn.c.putFreeConn(cn.addr, cn)
+	cn.checkin()
+	cn.pool.put(cn)
+}
+
+// checkin ends the request the connection was checked out for.
+func (cn *conn) checkin() {
+	if cn.inUse {
+		cn.inUse = false
+		cn.c.exit()
+	}
+}
+
+// close closes the connection and gives up its slot in the client's
+// pool. It is safe to call more than once.
+func (cn *conn) close() {
+	cn.checkin()
+	cn.closeOnce.Do(func() {
+		cn.nc.Close()
+		cn.pool.closed()
+	})
+}
+
+// expired reports whether the connection has exceeded the client's
+// ConnMaxLifetime or, if idle, its ConnMaxIdleTime.
+func (cn *conn) expired(now time.Time) bool {
+	c := cn.c
+	if c.ConnMaxLifetime > 0 && now.Sub(cn.createdAt) > c.ConnMaxLifetime {
+		return true
+	}
+	if c.ConnMaxIdleTime > 0 && now.Sub(cn.idleSince) > c.ConnMaxIdleTime {
+		return true
+	}
+	return false
+}
+
+// alive reports whether an idle connection is still usable: it holds
+// no unread bytes and the server hasn't closed it.
+func (cn *conn) alive() bool {
+	return cn.rw.Reader.Buffered() == 0 && connAlive(cn.nc)
 }
 
 func (cn *conn) extendDeadline() {
-	cn.nc.SetDeadline(time.Now().Add(cn.c.netTimeout()))
+	now := time.Now()
+	cn.nc.SetWriteDeadline(now.Add(cn.c.writeTimeout()))
+	cn.nc.SetReadDeadline(now.Add(cn.c.readTimeout()))
+}
+
+// extendWriteDeadline gives the next request WriteTimeout to be sent.
+func (cn *conn) extendWriteDeadline() {
+	cn.nc.SetWriteDeadline(time.Now().Add(cn.c.writeTimeout()))
+}
+
+// extendReadDeadline gives the next response ReadTimeout to arrive.
+func (cn *conn) extendReadDeadline() {
+	cn.nc.SetReadDeadline(time.Now().Add(cn.c.readTimeout()))
 }
 
 // condRelease releases this connection if the error pointed to by err
@@ -201,44 +397,36 @@ func (cn *conn) condRelease(err *error) {
 	if *err == nil || resumableError(*err) {
 		cn.release()
 	} else {
-		cn.nc.Close()
+		cn.close()
 	}
 }
 
-func (c *Client) putFreeConn(addr net.Addr, cn *conn) {
-	c.lk.Lock()
-	defer c.lk.Unlock()
-	if c.freeconn == nil {
-		c.freeconn = make(map[string][]*conn)
-	}
-	freelist := c.freeconn[addr.String()]
-	if len(freelist) >= c.maxIdleConns() {
-		cn.nc.Close()
-		return
+func (c *Client) netTimeout() time.Duration {
+	if c.Timeout != 0 {
+		return c.Timeout
 	}
-	c.freeconn[addr.String()] = append(freelist, cn)
+	return DefaultTimeout
 }
 
-func (c *Client) getFreeConn(addr net.Addr) (cn *conn, ok bool) {
-	c.lk.Lock()
-	defer c.lk.Unlock()
-	if c.freeconn == nil {
-		return nil, false
+func (c *Client) dialTimeout() time.Duration {
+	if c.DialTimeout != 0 {
+		return c.DialTimeout
 	}
-	freelist, ok := c.freeconn[addr.String()]
-	if !ok || len(freelist) == 0 {
-		return nil, false
+	return c.netTimeout()
+}
+
+func (c *Client) readTimeout() time.Duration {
+	if c.ReadTimeout != 0 {
+		return c.ReadTimeout
 	}
-	cn = freelist[len(freelist)-1]
-	c.freeconn[addr.String()] = freelist[:len(freelist)-1]
-	return cn, true
+	return c.netTimeout()
 }
 
-func (c *Client) netTimeout() time.Duration {
-	if c.Timeout != 0 {
-		return c.Timeout
+func (c *Client) writeTimeout() time.Duration {
+	if c.WriteTimeout != 0 {
+		return c.WriteTimeout
 	}
-	return DefaultTimeout
+	return c.netTimeout()
 }
 
 func (c *Client) maxIdleConns() int {
@@ -259,74 +447,257 @@ func (cte *ConnectTimeoutError) Error() string {
 	return "memcache: connect timeout to " + cte.Addr.String()
 }
 
-func (c *Client) dial(addr net.Addr) (net.Conn, error) {
-	type connError struct {
-		cn  net.Conn
-		err error
+// TCPOptions are socket options for TCP connections to servers.
+type TCPOptions struct {
+	// DisableNoDelay clears TCP_NODELAY, enabling Nagle's algorithm.
+	// Go sets TCP_NODELAY by default, which suits the small requests
+	// sent to the FPGA, so it is left alone unless this is set.
+	DisableNoDelay bool
+
+	// KeepAlive is the keep-alive period. If zero, keep-alives stay on
+	// with the period the connection was dialed with: Go's default of
+	// 15 seconds, unless the client's Dialer sets another. If negative,
+	// they are disabled.
+	KeepAlive time.Duration
+
+	// ReadBuffer and WriteBuffer set the socket's receive and send
+	// buffer sizes. If zero, the operating system's defaults are kept.
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+func (o *TCPOptions) apply(tc *net.TCPConn) error {
+	if o.DisableNoDelay {
+		if err := tc.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if err := tc.SetKeepAlive(o.KeepAlive >= 0); err != nil {
+		return err
+	}
+	if o.KeepAlive > 0 {
+		if err := tc.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	nc, err := net.DialTimeout(addr.Network(), addr.String(), c.netTimeout())
+func (c *Client) dial(addr net.Addr) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.dialTimeout())
+	defer cancel()
+
+	dial := c.Dialer
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	nc, err := dial(ctx, addr.Network(), addr.String())
 	if err == nil {
+		if tc, ok := nc.(*net.TCPConn); ok && c.TCP != nil {
+			if err := c.TCP.apply(tc); err != nil {
+				nc.Close()
+				return nil, err
+			}
+		}
 		return nc, nil
 	}
 
 	if ne, ok := err.(net.Error); ok && ne.Timeout() {
 		return nil, &ConnectTimeoutError{addr}
 	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, &ConnectTimeoutError{addr}
+	}
 
 	return nil, err
 }
 
 func (c *Client) getConn(addr net.Addr) (*conn, error) {
-	cn, ok := c.getFreeConn(addr)
+	if !c.enter() {
+		return nil, ErrClientClosed
+	}
+	p := c.pool(addr)
+	cn, ok := p.get()
 	if ok {
+		cn.inUse = true
+		cn.extendDeadline()
+		return cn, nil
+	}
+	cn, err := p.reserve()
+	if err != nil {
+		c.exit()
+		return nil, err
+	}
+	if cn != nil {
+		cn.inUse = true
 		cn.extendDeadline()
 		return cn, nil
 	}
 	nc, err := c.dial(addr)
 	if err != nil {
+		p.closed()
+		c.exit()
 		return nil, err
 	}
 	cn = &conn{
-		nc:   nc,
-		addr: addr,
-		rw:   bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
-		c:    c,
+		nc:        nc,
+		addr:      addr,
+		rw:        bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+		c:         c,
+		pool:      p,
+		createdAt: time.Now(),
+		inUse:     true,
 	}
 	cn.extendDeadline()
 	return cn, nil
 }
 
-func (c *Client) onItem(item *Item, fn func(*Client, *bufio.ReadWriter, *Item) error) error {
-	addr, err := c.selector.PickServer(item.Key)
-	if err != nil {
-		return err
+// store sends the storage command verb for item, coalesced with other
+// requests to the same server if the client's Coalesce policy allows.
+func (c *Client) store(verb string, item *Item) error {
+	item = c.serverItem(item)
+	defer c.invalidateNear(item.Key)
+	if !legalKey(item.Key) {
+		return ErrMalformedKey
 	}
-	cn, err := c.getConn(addr)
+	item, err := c.fitLayout(verb, item)
 	if err != nil {
 		return err
 	}
-	defer cn.condRelease(&err)
-	if err = fn(c, cn.rw, item); err != nil {
+	if c.coalescing() {
+		addr, err := c.selector.PickServer(item.Key)
+		if err != nil {
+			return err
+		}
+		cmd := appendStoreLine(nil, verb, item)
+		cmd = append(append(cmd, item.Value...), crlf...)
+		return c.coalesce(addr, cmd, func(r *bufio.Reader) error {
+			line, err := c.readResponseLine(r)
+			if err != nil {
+				return err
+			}
+			return storeResult(verb, line)
+		})
+	}
+	return c.onItem(item, func(c *Client, cn *conn, item *Item) error {
+		return c.populateOne(cn, verb, item)
+	})
+}
+
+func (c *Client) onItem(item *Item, fn func(*Client, *conn, *Item) error) error {
+	addr, err := c.selector.PickServer(item.Key)
+	if err != nil {
 		return err
 	}
-	return nil
+	return c.withAddrConn(addr, func(cn *conn) error {
+		return fn(c, cn, item)
+	})
+}
+
+// FlushOptions modify the flush_all command sent by FlushAllWithOptions.
+type FlushOptions struct {
+	// Delay postpones the invalidation of the items by the given
+	// duration, rounded down to whole seconds.
+	Delay time.Duration
+
+	// NoReply asks the servers not to answer, so that the command
+	// doesn't wait for them. Server errors then go unnoticed.
+	NoReply bool
 }
 
+// FlushAll invalidates the items of every server. The servers are
+// flushed concurrently; if some of them fail, a MultiError is returned.
 func (c *Client) FlushAll() error {
-	return c.selector.Each(c.flushAllFromAddr)
+	_, err := c.FlushAllWithOptions(FlushOptions{})
+	return err
+}
+
+// FlushAllWithOptions is like FlushAll, but takes options and returns
+// the outcome for every server, nil where the flush succeeded.
+func (c *Client) FlushAllWithOptions(opts FlushOptions) (map[net.Addr]error, error) {
+	addrs, err := c.servers()
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[net.Addr]error, len(addrs))
+	var mu sync.Mutex
+	err = fanOut(addrs, func(addr net.Addr) error {
+		err := c.flushAllFromAddr(addr, opts)
+		mu.Lock()
+		results[addr] = err
+		mu.Unlock()
+		return err
+	})
+	if c.NearCache != nil {
+		c.near.purge()
+	}
+	return results, err
 }
 
 // Get gets the item for the given key. ErrCacheMiss is returned for a
 // memcache cache miss. The key must be at most 250 bytes in length.
 func (c *Client) Get(key string, scancount int) (item *Item, err error) {
-	err = c.withKeyAddr(key, func(addr net.Addr) error {
-		return c.getFromAddr(addr, []string{key}, func(it *Item) { item = it }, scancount)
+	skey := c.serverKey(key)
+	if c.NearCache != nil && scancount == 1 {
+		return c.nearGet(key, skey)
+	}
+	err = c.retry("get", func() error {
+		if addrs, ok := c.hedgeAddrs(skey); ok {
+			it, err := c.hedgedRead(addrs, func(cn *conn, cb func(*Item)) error {
+				return c.getHedged(cn, skey, cb, scancount)
+			})
+			item = it
+			return err
+		}
+		return c.withReadKeyAddr(skey, func(addr net.Addr) error {
+			return c.getFromAddr(addr, []string{skey}, func(it *Item) { item = it }, scancount)
+		})
 	})
 	/*if err == nil && item == nil {
 		err = ErrCacheMiss
 	}*/
-	return
+	return clientItem(item, key), err
+}
+
+// GetInto gets the item for the given key, as Get does, and reads its
+// value into buf instead of a newly allocated slice. If buf's capacity
+// is too small for the value, a new slice is allocated instead. The
+// returned item's Value shares buf's memory, so buf must not be reused
+// while the item is in use. ErrCacheMiss is returned for a cache miss.
+//
+// Unlike Get, GetInto decodes the value, and it is neither hedged nor
+// coalesced.
+func (c *Client) GetInto(key string, buf []byte) (item *Item, err error) {
+	skey := c.serverKey(key)
+	err = c.retry("get", func() error {
+		return c.withReadKeyAddr(skey, func(addr net.Addr) error {
+			return c.withAddrConn(addr, func(cn *conn) error {
+				rb := getReqBuf()
+				defer putReqBuf(rb)
+				rb.line = appendGetLine(rb.line, []string{skey})
+				if err := c.send(cn, rb, nil, false); err != nil {
+					return err
+				}
+				cn.extendReadDeadline()
+				return c.readValuesInto(cn.rw.Reader, "get", buf, func(it *Item) { item = it })
+			})
+		})
+	})
+	if err == nil && item == nil {
+		err = ErrCacheMiss
+	}
+	return clientItem(item, key), err
 }
 
 // Ret, regular expression get
@@ -334,122 +705,72 @@ func (c *Client) Ret(ritem *Item, scancount int) (item *Item, err error) {
    if len(ritem.Value) != 32 {
       return nil, fmt.Errorf("memcache: unexpected value length in ret request: %s", ritem.Value)
    }
-   err = c.withKeyAddr(ritem.Key, func(addr net.Addr) error {
-      return c.retFromAddr(addr, ritem, func(it *Item) { item = it}, scancount )
-   })
+   sitem := c.serverItem(ritem)
+	err = c.retry("ret", func() error {
+		if addrs, ok := c.hedgeAddrs(sitem.Key); ok {
+			it, err := c.hedgedRead(addrs, func(cn *conn, cb func(*Item)) error {
+				return c.retHedged(cn, sitem, cb, scancount)
+			})
+			item = it
+			return err
+		}
+		return c.withReadKeyAddr(sitem.Key, func(addr net.Addr) error {
+			return c.retFromAddr(addr, sitem, func(it *Item) { item = it }, scancount)
+		})
+	})
    /*if err == nil && item == nil {
       err = ErrCacheMiss
    }*/
-   return
+   return clientItem(item, ritem.Key), err
 }
 
 // GET over UDP
 func (c *Client) GetUDP(rw *bufio.ReadWriter, key string, scancount int) (item *Item, err error) {
-   err = c.getFromUDP(rw, []string{key}, scancount, func(it *Item) { item = it })
-   return
+   err = c.getFromUDP(rw, []string{c.serverKey(key)}, scancount, func(it *Item) { item = it })
+   return clientItem(item, key), err
 }
 
 func (c *Client) getFromUDP(rw *bufio.ReadWriter, keys []string, scancount int, cb func(*Item)) error {
 
-   // Add Zsolt header
-   padding := []byte{0, 0, 0, 0, 0, 0, 0, 0}
-   padLen := 0
-   mheader := fmt.Sprintf("get %s\r\n", strings.Join(keys, " "))
-   length := len(mheader)
-   if length % 8 != 0 {
-      padLen = 8 - (length % 8)
-   }
-   length += padLen
-   length /= 8
-   zheader := []byte{0xFF, 0xFF, 0, 0, byte(length), 0, 0, 0}
-                       //0, 0, 0, 0, 0, 0, 0, 0}
-
-   if c.UseZsolt {
-      if _, err := rw.Write(zheader); err != nil {
-         return err
-      }
-   }
-
-	if _, err := fmt.Fprintf(rw, "%s", mheader); err != nil {
-		return err
-	}
-   if c.UseZsolt && padLen != 0 {
-      if _, err := rw.Write(padding[0:padLen]); err != nil {
-         return err
-      }
+   rb := getReqBuf()
+   defer putReqBuf(rb)
+   rb.line = appendGetLine(rb.line, keys)
+   if err := c.sendBuffered(rw.Writer, rb, zsoltUDPHeaderLen, nil, false); err != nil {
+      return err
    }
 	if err := rw.Flush(); err != nil {
 		return err
 	}
-   for i := 0; i < scancount; i++ {
+   return readScan(scancount, func() error {
       if c.UseZsolt {
-	      if err := parseZsoltResponse(rw.Reader, cb); err != nil {
-		      return err
-	      }
-      } else {
-	      if err := parseGetResponse(rw.Reader, cb); err != nil {
-		      return err
-	      }
+	      return parseZsoltResponse(rw.Reader, cb)
       }
-   }
-	return nil
+	   return parseGetResponse(rw.Reader, cb)
+   })
 }
 
 // RET over UDP
 func (c *Client) RetUDP(rw *bufio.ReadWriter, ritem *Item, scancount int) (item *Item, err error) {
-   err = c.retFromUDP(rw, ritem, scancount, func(it *Item) { item = it })
-   return
+   err = c.retFromUDP(rw, c.serverItem(ritem), scancount, func(it *Item) { item = it })
+   return clientItem(item, ritem.Key), err
 }
 func (c *Client) retFromUDP(rw *bufio.ReadWriter, item *Item, scancount int, cb func(*Item)) error {
 
-   // Add Zsolt header
-   padding := []byte{0, 0, 0, 0, 0, 0, 0, 0}
-   padLen := 0
-   mheader := fmt.Sprintf("ret %s 0 0 32\r\n", item.Key)
-   length := len(mheader) + 34
-   if length % 8 != 0 {
-      padLen = 8 - (length % 8)
-   }
-   length += padLen
-   length /= 8
-   zheader := []byte{0xFF, 0xFF, 0, 0, byte(length), 0, 0, 0}
-                    //0, 0, 0, 0, 0, 0, 0, 0}
-
-   if c.UseZsolt {
-      if _, err := rw.Write(zheader); err != nil {
-         return err
-      }
-   }
-
-	if _, err := fmt.Fprintf(rw, "%s", mheader); err != nil {
-		return err
-	}
-   if _,err := rw.Write(item.Value); err != nil {
+   rb := getReqBuf()
+   defer putReqBuf(rb)
+   rb.line = appendDataLine(rb.line, "ret", item.Key, len(item.Value))
+   if err := c.sendBuffered(rw.Writer, rb, zsoltUDPHeaderLen, item.Value, true); err != nil {
       return err
-   }
-   if _, err := rw.Write(crlf); err != nil {
-	   return err
-	}
-   if c.UseZsolt && padLen != 0 {
-      if _, err := rw.Write(padding[0:padLen]); err != nil {
-         return err
-      }
    }
 	if err := rw.Flush(); err != nil {
 		return err
 	}
-   for i := 0; i < scancount; i++ {
+   return readScan(scancount, func() error {
       if c.UseZsolt {
-	      if err := parseZsoltRetResponse(rw.Reader, cb); err != nil {
-		      return err
-	      }
-      } else {
-	      if err := parseGetResponse(rw.Reader, cb); err != nil {
-		      return err
-	      }
+	      return parseZsoltRetResponse(rw.Reader, cb)
       }
-   }
-	return nil
+	   return parseGetResponse(rw.Reader, cb)
+   })
 }
 
 // Touch updates the expiry for the given key. The seconds parameter is either
@@ -457,9 +778,34 @@ func (c *Client) retFromUDP(rw *bufio.ReadWriter, item *Item, scancount int, cb
 // into the future at which time the item will expire. ErrCacheMiss is returned if the
 // key is not in the cache. The key must be at most 250 bytes in length.
 func (c *Client) Touch(key string, seconds int32) (err error) {
-	return c.withKeyAddr(key, func(addr net.Addr) error {
-		return c.touchFromAddr(addr, []string{key}, seconds)
+	key = c.serverKey(key)
+	return c.retry("touch", func() error {
+		return c.withKeyAddr(key, func(addr net.Addr) error {
+			return c.touchFromAddr(addr, []string{key}, seconds)
+		})
+	})
+}
+
+// GetAndTouch gets the item for the given key and updates its expiry,
+// as Touch does, in one request. ErrCacheMiss is returned for a cache
+// miss. The returned item can be used with CompareAndSwap.
+func (c *Client) GetAndTouch(key string, seconds int32) (item *Item, err error) {
+	skey := c.serverKey(key)
+	err = c.retry("gat", func() error {
+		return c.withKeyRw(skey, func(rw *bufio.ReadWriter) error {
+			if err := c.writeFramed(rw.Writer, []byte(fmt.Sprintf("gats %d %s\r\n", seconds, skey))); err != nil {
+				return err
+			}
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+			return c.readValues(rw.Reader, "gats", func(it *Item) { item = it })
+		})
 	})
+	if err == nil && item == nil {
+		err = ErrCacheMiss
+	}
+	return clientItem(item, key), err
 }
 
 func (c *Client) withKeyAddr(key string, fn func(net.Addr) error) (err error) {
@@ -473,13 +819,46 @@ func (c *Client) withKeyAddr(key string, fn func(net.Addr) error) (err error) {
 	return fn(addr)
 }
 
+// withReadKeyAddr is withKeyAddr for reads, which go to the server the
+// selector picks for reads if it is a ReadSelector.
+func (c *Client) withReadKeyAddr(key string, fn func(net.Addr) error) (err error) {
+	if !legalKey(key) {
+		return ErrMalformedKey
+	}
+	addr, err := c.pickReadServer(key)
+	if err != nil {
+		return err
+	}
+	return fn(addr)
+}
+
+func (c *Client) pickReadServer(key string) (net.Addr, error) {
+	if rs, ok := c.selector.(ReadSelector); ok {
+		return rs.PickReadServer(key)
+	}
+	return c.selector.PickServer(key)
+}
+
 func (c *Client) withAddrRw(addr net.Addr, fn func(*bufio.ReadWriter) error) (err error) {
+	return c.withAddrConn(addr, func(cn *conn) error {
+		return fn(cn.rw)
+	})
+}
+
+func (c *Client) withAddrConn(addr net.Addr, fn func(*conn) error) (err error) {
+	if err := c.allow(addr); err != nil {
+		return err
+	}
+	start := time.Now()
 	cn, err := c.getConn(addr)
 	if err != nil {
+		c.observe(addr, err, time.Since(start))
 		return err
 	}
 	defer cn.condRelease(&err)
-	return fn(cn.rw)
+	err = annotateError(fn(cn), "", addr)
+	c.observe(addr, err, time.Since(start))
+	return err
 }
 
 func (c *Client) withKeyRw(key string, fn func(*bufio.ReadWriter) error) error {
@@ -489,121 +868,83 @@ func (c *Client) withKeyRw(key string, fn func(*bufio.ReadWriter) error) error {
 }
 
 func (c *Client) getFromAddr(addr net.Addr, keys []string, cb func(*Item), scancount int) error {
-	return c.withAddrRw(addr, func(rw *bufio.ReadWriter) error {
-      // Add Zsolt header
-      padding := []byte{0, 0, 0, 0, 0, 0, 0, 0}
-      padLen := 0
-      mheader := fmt.Sprintf("get %s\r\n", strings.Join(keys, " "))
-      length := len(mheader)
-      if length % 8 != 0 {
-         padLen = 8 - (length % 8)
-      }
-      length += padLen
-      length /= 8
-      zheader := []byte{0xFF, 0xFF, 0, 0, byte(length), 0, 0, 0, 
-                       0, 0, 0, 0, 0, 0, 0, 0}
-
-      if c.UseZsolt {
-         if _, err := rw.Write(zheader); err != nil {
-            return err
-         }
-      }
+	if c.coalescing() {
+		return c.getCoalesced(addr, keys, cb, scancount)
+	}
+	return c.withAddrConn(addr, func(cn *conn) error {
+		return c.getFromConn(cn, keys, cb, scancount)
+	})
+}
 
-		if _, err := fmt.Fprintf(rw, "%s", mheader); err != nil {
-			return err
-		}
-      if c.UseZsolt && padLen != 0 {
-         if _, err := rw.Write(padding[0:padLen]); err != nil {
-            return err
-         }
+func (c *Client) getFromConn(cn *conn, keys []string, cb func(*Item), scancount int) error {
+	rw := cn.rw
+      rb := getReqBuf()
+      defer putReqBuf(rb)
+      rb.line = appendGetLine(rb.line, keys)
+      if err := c.send(cn, rb, nil, false); err != nil {
+         return err
       }
-		if err := rw.Flush(); err != nil {
-			return err
-		}
-      for i := 0; i < scancount; i++ {
+      return readScan(scancount, func() error {
+         cn.extendReadDeadline()
          if c.UseZsolt {
-		      if err := parseZsoltResponse(rw.Reader, cb); err != nil {
-			      return err
-		      }
-         } else {
-		      if err := parseGetResponse(rw.Reader, cb); err != nil {
-			      return err
-		      }
+		      return annotateError(parseZsoltResponse(rw.Reader, cb), "get", nil)
          }
-      }
-		return nil
-	})
+		   return annotateError(parseGetResponse(rw.Reader, cb), "get", nil)
+      })
 }
 
 func (c *Client) retFromAddr(addr net.Addr, item *Item, cb func(*Item), scancount int) error {
-	return c.withAddrRw(addr, func(rw *bufio.ReadWriter) error {
-      // Add Zsolt header
-      padding := []byte{0, 0, 0, 0, 0, 0, 0, 0}
-      padLen := 0
-      mheader := fmt.Sprintf("ret %s 0 0 32\r\n", item.Key)
-      length := len(mheader) + 34
-      if length % 8 != 0 {
-         padLen = 8 - (length % 8)
-      }
-      length += padLen
-      length /= 8
-      zheader := []byte{0xFF, 0xFF, 0, 0, byte(length), 0, 0, 0, 
-                       0, 0, 0, 0, 0, 0, 0, 0}
-
-      if c.UseZsolt {
-         if _, err := rw.Write(zheader); err != nil {
-            return err
-         }
-      }
+	return c.withAddrConn(addr, func(cn *conn) error {
+		return c.retFromConn(cn, item, cb, scancount)
+	})
+}
 
-		if _, err := fmt.Fprintf(rw, "%s", mheader); err != nil {
-			return err
-		}
-      if _,err := rw.Write(item.Value); err != nil {
+func (c *Client) retFromConn(cn *conn, item *Item, cb func(*Item), scancount int) error {
+	rw := cn.rw
+      rb := getReqBuf()
+      defer putReqBuf(rb)
+      rb.line = appendDataLine(rb.line, "ret", item.Key, len(item.Value))
+      if err := c.send(cn, rb, item.Value, true); err != nil {
          return err
       }
-      if _, err := rw.Write(crlf); err != nil {
-		   return err
-	   }
-      if c.UseZsolt && padLen != 0 {
-         if _, err := rw.Write(padding[0:padLen]); err != nil {
-            return err
-         }
-      }
-		if err := rw.Flush(); err != nil {
-			return err
-		}
-      for i := 0; i < scancount; i++ {
+      return readScan(scancount, func() error {
+         cn.extendReadDeadline()
          if c.UseZsolt {
-		      if err := parseZsoltRetResponse(rw.Reader, cb); err != nil {
-			      return err
-		      }
-         } else {
-		      if err := parseGetResponse(rw.Reader, cb); err != nil {
-			      return err
-		      }
+		      return annotateError(parseZsoltRetResponse(rw.Reader, cb), "ret", nil)
          }
-      }
-		return nil
-	})
+		   return annotateError(parseGetResponse(rw.Reader, cb), "ret", nil)
+      })
 }
 
 
 // flushAllFromAddr send the flush_all command to the given addr
-func (c *Client) flushAllFromAddr(addr net.Addr) error {
+func (c *Client) flushAllFromAddr(addr net.Addr, opts FlushOptions) error {
 	return c.withAddrRw(addr, func(rw *bufio.ReadWriter) error {
-		if _, err := fmt.Fprintf(rw, "flush_all\r\n"); err != nil {
+		cmd := []byte("flush_all")
+		if secs := int64(opts.Delay / time.Second); secs > 0 {
+			cmd = strconv.AppendInt(append(cmd, ' '), secs, 10)
+		}
+		if opts.NoReply {
+			cmd = append(cmd, " noreply"...)
+		}
+		if err := c.writeFramed(rw.Writer, append(cmd, crlf...)); err != nil {
 			return err
 		}
 		if err := rw.Flush(); err != nil {
 			return err
 		}
-		line, err := rw.ReadSlice('\n')
+		if opts.NoReply {
+			return nil
+		}
+		line, err := c.readResponseLine(rw.Reader)
 		if err != nil {
 			return err
 		}
+		if err := responseError("flush_all", line); err != nil {
+			return err
+		}
 		switch {
-		case bytes.Equal(line, resultOk):
+		case bytes.Contains(line, resultOk):
 			break
 		default:
 			return fmt.Errorf("memcache: unexpected response line from flush_all: %q", string(line))
@@ -615,20 +956,17 @@ func (c *Client) flushAllFromAddr(addr net.Addr) error {
 func (c *Client) touchFromAddr(addr net.Addr, keys []string, expiration int32) error {
 	return c.withAddrRw(addr, func(rw *bufio.ReadWriter) error {
 		for _, key := range keys {
-			if _, err := fmt.Fprintf(rw, "touch %s %d\r\n", key, expiration); err != nil {
-				return err
-			}
-			if err := rw.Flush(); err != nil {
+			line, err := c.roundTrip(rw, []byte(fmt.Sprintf("touch %s %d\r\n", key, expiration)))
+			if err != nil {
 				return err
 			}
-			line, err := rw.ReadSlice('\n')
-			if err != nil {
+			if err := responseError("touch", line); err != nil {
 				return err
 			}
 			switch {
-			case bytes.Equal(line, resultTouched):
+			case bytes.Contains(line, resultTouched):
 				break
-			case bytes.Equal(line, resultNotFound):
+			case bytes.Contains(line, resultNotFound):
 				return ErrCacheMiss
 			default:
 				return fmt.Errorf("memcache: unexpected response line from touch: %q", string(line))
@@ -642,12 +980,26 @@ func (c *Client) touchFromAddr(addr net.Addr, keys []string, expiration int32) e
 // items may have fewer elements than the input slice, due to memcache
 // cache misses. Each key must be at most 250 bytes in length.
 // If no error is returned, the returned map will also be non-nil.
+// If some servers fail, the items from the others are returned along
+// with a MultiError.
 func (c *Client) GetMulti(keys []string) (map[string]*Item, error) {
 	var lk sync.Mutex
 	m := make(map[string]*Item)
+	var clientKeys map[string]string // server key -> client key
+	if c.KeyTransform != nil {
+		skeys := c.serverKeys(keys)
+		clientKeys = make(map[string]string, len(keys))
+		for i, key := range keys {
+			clientKeys[skeys[i]] = key
+		}
+		keys = skeys
+	}
 	addItemToMap := func(it *Item) {
 		lk.Lock()
 		defer lk.Unlock()
+		if clientKeys != nil {
+			it.Key = clientKeys[it.Key]
+		}
 		m[it.Key] = it
 	}
 
@@ -656,26 +1008,22 @@ func (c *Client) GetMulti(keys []string) (map[string]*Item, error) {
 		if !legalKey(key) {
 			return nil, ErrMalformedKey
 		}
-		addr, err := c.selector.PickServer(key)
+		addr, err := c.pickReadServer(key)
 		if err != nil {
 			return nil, err
 		}
 		keyMap[addr] = append(keyMap[addr], key)
 	}
 
-	ch := make(chan error, buffered)
-	for addr, keys := range keyMap {
-		go func(addr net.Addr, keys []string) {
-			ch <- c.getFromAddr(addr, keys, addItemToMap, 1)
-		}(addr, keys)
-	}
-
-	var err error
-	for _ = range keyMap {
-		if ge := <-ch; ge != nil {
-			err = ge
-		}
+	addrs := make([]net.Addr, 0, len(keyMap))
+	for addr := range keyMap {
+		addrs = append(addrs, addr)
 	}
+	err := fanOut(addrs, func(addr net.Addr) error {
+		return c.retry("get", func() error {
+			return c.getFromAddr(addr, keyMap[addr], addItemToMap, 1)
+		})
+	})
 	return m, err
 }
 
@@ -703,6 +1051,9 @@ func parseGetResponse(r *bufio.Reader, cb func(*Item)) error {
 			return err
 		}
       //totalbytes += len(line)
+		if err := responseError("", line); err != nil {
+			return err
+		}
 		if bytes.Contains(line, resultEnd) { //used to be Equal
          /*if totalbytes % 8 != 0 {
             r.Discard(8 - (totalbytes % 8))
@@ -751,6 +1102,9 @@ func parseZsoltResponse(r *bufio.Reader, cb func(*Item)) error {
 		if err != nil {
 			return err
 		}
+		if err := responseError("", line); err != nil {
+			return err
+		}
       //totalbytes += len(line)
 		if bytes.Contains(line, resultEnd) {
          /*if totalbytes % 8 != 0 {
@@ -786,6 +1140,12 @@ func parseZsoltRetResponse(r *bufio.Reader, cb func(*Item)) error {
       }     
 
       totalbytes += len(line)
+      if err := responseError("", line); err != nil {
+         if totalbytes % 8 != 0 {
+            r.Discard(8 - (totalbytes % 8))
+         }
+         return err
+      }
 
       if bytes.Contains(line, resultEnd) {                  
          if totalbytes % 8 !=0 {
@@ -799,22 +1159,53 @@ func parseZsoltRetResponse(r *bufio.Reader, cb func(*Item)) error {
 // scanGetResponseLine populates it and returns the declared size of the item.
 // It does not read the bytes of the item.
 func scanGetResponseLine(line []byte, it *Item) (size int, err error) {
-	pattern := "VALUE %s %d %d %d\r\n"
-	dest := []interface{}{&it.Key, &it.Flags, &size, &it.casid}
-	if bytes.Count(line, space) == 3 {
-		pattern = "VALUE %s %d %d\r\n"
-		dest = dest[:3]
-	}
-	n, err := fmt.Sscanf(string(line), pattern, dest...)
-	if err != nil || n != len(dest) {
+	key, flags, size, casid, ok := parseValueLine(line)
+	if !ok {
 		return -1, fmt.Errorf("memcache: unexpected line in get response: %q", line)
 	}
+	it.Key, it.Flags, it.casid = string(key), flags, casid
 	return size, nil
 }
 
+// parseValueLine parses the line "VALUE <key> <flags> <bytes> [<cas>]"
+// without allocating.
+func parseValueLine(line []byte) (key []byte, flags uint32, size int, casid uint64, ok bool) {
+	var f [5][]byte
+	n := 0
+	rest := bytes.TrimSuffix(line, crlf)
+	for len(rest) > 0 && n < len(f) {
+		i := bytes.IndexByte(rest, ' ')
+		if i < 0 {
+			f[n], rest = rest, nil
+		} else {
+			f[n], rest = rest[:i], rest[i+1:]
+		}
+		n++
+	}
+	if len(rest) > 0 || n < 4 || string(f[0]) != "VALUE" {
+		return nil, 0, 0, 0, false
+	}
+	fl, err := strconv.ParseUint(string(f[2]), 10, 32)
+	if err != nil {
+		return nil, 0, 0, 0, false
+	}
+	sz, err := strconv.ParseUint(string(f[3]), 10, 31)
+	if err != nil {
+		return nil, 0, 0, 0, false
+	}
+	if n == 5 {
+		if casid, err = strconv.ParseUint(string(f[4]), 10, 64); err != nil {
+			return nil, 0, 0, 0, false
+		}
+	}
+	return f[1], uint32(fl), int(sz), casid, true
+}
+
 // Set writes the given item, unconditionally.
 func (c *Client) Set(item *Item) error {
-	return c.onItem(item, (*Client).set)
+	return c.retry("set", func() error {
+		return c.store("set", item)
+	})
 }
 
 
@@ -826,43 +1217,20 @@ func (c *Client) SetJSON(item *Item) error {
 }
 
 func (c *Client) SetUDP(rw *bufio.ReadWriter, item *Item) error {
+   item = c.serverItem(item)
+   defer c.invalidateNear(item.Key)
    if !legalKey(item.Key) {
       return ErrMalformedKey
    }
-   // Change for Zsolts protocol
-   padding := []byte{0, 0, 0, 0, 0, 0, 0, 0}
-   padLen := 0
-
-	var err error
-
-   //Changed for Zsolts protocol
-   mheader := fmt.Sprintf("set %s 0 0 %d\r\n", item.Key, len(item.Value))
-
-   length := len(mheader) + len(item.Value) + 2
-   if length % 8 != 0 {
-       padLen = 8 - (length % 8)
-   }
-   length += padLen
-   length /= 8
-   zheader := []byte{0xFF, 0xFF, 0, 0, byte(length), 0, 0, 0}
-   if c.UseZsolt {
-      if _, err = rw.Write(zheader); err != nil {
-         return err
-      }
+   item, err := c.fitLayout("set", item)
+   if err != nil {
+      return err
    }
-	if _, err = fmt.Fprintf(rw, "%s", mheader); err != nil {
-		return err
-	}
-	if _, err = rw.Write(item.Value); err != nil {
-		return err
-	}
-	if _, err := rw.Write(crlf); err != nil {
-		return err
-	}
-   if c.UseZsolt && padLen != 0 {
-      if _, err := rw.Write(padding[0:padLen]); err != nil {
-         return err
-      }
+   rb := getReqBuf()
+   defer putReqBuf(rb)
+   rb.line = appendDataLine(rb.line, "set", item.Key, len(item.Value))
+   if err := c.sendBuffered(rw.Writer, rb, zsoltUDPHeaderLen, item.Value, true); err != nil {
+      return err
    }
 	if err := rw.Flush(); err != nil {
 		return err
@@ -893,32 +1261,43 @@ func (c *Client) SetUDP(rw *bufio.ReadWriter, item *Item) error {
 	case bytes.Contains(line, resultNotFound):
 		return ErrCacheMiss
 	}
+	if err := responseError("set", line); err != nil {
+		return err
+	}
 	return fmt.Errorf("memcache: unexpected response line from set: %q", string(line))
    //return c.populateOne(rw, "set", item)
 }
 
-func (c *Client) set(rw *bufio.ReadWriter, item *Item) error {
-	return c.populateOne(rw, "set", item)
-}
-
 // Add writes the given item, if no value already exists for its
 // key. ErrNotStored is returned if that condition is not met.
 func (c *Client) Add(item *Item) error {
-	return c.onItem(item, (*Client).add)
-}
-
-func (c *Client) add(rw *bufio.ReadWriter, item *Item) error {
-	return c.populateOne(rw, "add", item)
+	return c.retry("add", func() error {
+		return c.store("add", item)
+	})
 }
 
 // Replace writes the given item, but only if the server *does*
 // already hold data for this key
 func (c *Client) Replace(item *Item) error {
-	return c.onItem(item, (*Client).replace)
+	return c.retry("replace", func() error {
+		return c.store("replace", item)
+	})
+}
+
+// Append adds the given item's value after the value the server already
+// holds for its key. The item's flags and expiration are ignored.
+// ErrNotStored is returned if the key isn't in the cache.
+func (c *Client) Append(item *Item) error {
+	return c.retry("append", func() error {
+		return c.store("append", item)
+	})
 }
 
-func (c *Client) replace(rw *bufio.ReadWriter, item *Item) error {
-	return c.populateOne(rw, "replace", item)
+// Prepend is like Append, but adds the value before the existing one.
+func (c *Client) Prepend(item *Item) error {
+	return c.retry("prepend", func() error {
+		return c.store("prepend", item)
+	})
 }
 
 // CompareAndSwap writes the given item that was previously returned
@@ -929,90 +1308,54 @@ func (c *Client) replace(rw *bufio.ReadWriter, item *Item) error {
 // calls. ErrNotStored is returned if the value was evicted in between
 // the calls.
 func (c *Client) CompareAndSwap(item *Item) error {
-	return c.onItem(item, (*Client).cas)
-}
-
-func (c *Client) cas(rw *bufio.ReadWriter, item *Item) error {
-	return c.populateOne(rw, "cas", item)
+	return c.retry("cas", func() error {
+		return c.store("cas", item)
+	})
 }
 
-func (c *Client) populateOne(rw *bufio.ReadWriter, verb string, item *Item) error {
+func (c *Client) populateOne(cn *conn, verb string, item *Item) error {
 	if !legalKey(item.Key) {
 		return ErrMalformedKey
 	}
-   // Change for Zsolts protocol
-   padding := []byte{0, 0, 0, 0, 0, 0, 0, 0}
-   padLen := 0
-
-	var err error
-	if verb == "cas" {
-		_, err = fmt.Fprintf(rw, "%s %s %d %d %d %d\r\n",
-			verb, item.Key, item.Flags, item.Expiration, len(item.Value), item.casid)
-	} else {
-      //Changed for Zsolts protocol
-      mheader := fmt.Sprintf("%s %s %d %d %d\r\n",
-            verb, item.Key, item.Flags, item.Expiration, len(item.Value))
-
-      length := len(mheader) + len(item.Value) + 2
-      if length % 8 != 0 {
-         padLen = 8 - (length % 8)
-      }
-      length += padLen
-      length /= 8
-      zheader := []byte{0xFF, 0xFF, 0, 0, byte(length), 0, 0, 0,
-                       0, 0, 0, 0, 0, 0, 0, 0}
-      if c.UseZsolt {
-         if _, err = rw.Write(zheader); err != nil {
-            return err
-         }
-      }
-		_, err = fmt.Fprintf(rw, "%s", mheader)
-            //verb, item.Key, item.Flags, item.Expiration, len(item.Value))
-
-	}
-	if err != nil {
-		return err
-	}
-	if _, err = rw.Write(item.Value); err != nil {
-		return err
-	}
-	if _, err := rw.Write(crlf); err != nil {
+	rb := getReqBuf()
+	defer putReqBuf(rb)
+	rb.line = appendStoreLine(rb.line, verb, item)
+	if err := c.send(cn, rb, item.Value, true); err != nil {
 		return err
 	}
-   if c.UseZsolt && padLen != 0 {
-      if _, err := rw.Write(padding[0:padLen]); err != nil {
-         return err
-      }
-   }
-	if err := rw.Flush(); err != nil {
+	line, err := c.readResponseLine(cn.rw.Reader)
+	if err != nil {
 		return err
 	}
-   //Zsolt Discard 8 dummy bytes
-   if c.UseZsolt {
-      if _, err := rw.Discard(8); err != nil {
-         return err
-      }
-   }
-	line, err := rw.ReadSlice('\n')
-   if err != nil {
-      return err
-   }
-   for bytes.Contains(line, []byte("\r")) == false {
-      line, err = rw.ReadSlice('\n')
-	   if err != nil {
-		   return err
-	   }
-   }
+	return storeResult(verb, line)
+}
+
+// writeStore writes the storage command verb for item to w, framed as
+// the client's protocol requires.
+func (c *Client) writeStore(w *bufio.Writer, verb string, item *Item) error {
+	rb := getReqBuf()
+	defer putReqBuf(rb)
+	rb.line = appendStoreLine(rb.line, verb, item)
+	return c.sendBuffered(w, rb, zsoltHeaderLen, item.Value, true)
+}
+
+// storeResult returns the error for the response line to a storage
+// command such as set or cas, nil if the item was stored.
+func storeResult(verb string, line []byte) error {
 	switch {
-	case bytes.Contains(line, resultStored):
-		return nil
+	// NOT_STORED contains STORED, so it is checked first.
 	case bytes.Contains(line, resultNotStored):
 		return ErrNotStored
+	case bytes.Contains(line, resultStored):
+		return nil
 	case bytes.Contains(line, resultExists):
 		return ErrCASConflict
 	case bytes.Contains(line, resultNotFound):
 		return ErrCacheMiss
 	}
+	if err := responseError(verb, line); err != nil {
+		return err
+	}
 	return fmt.Errorf("memcache: unexpected response line from %q: %q", verb, string(line))
 }
 
@@ -1045,22 +1388,28 @@ func writeExpectf(rw *bufio.ReadWriter, expect []byte, format string, args ...in
 	case bytes.Equal(line, resultNotFound):
 		return ErrCacheMiss
 	}
+	if err := responseError(strings.Fields(format)[0], line); err != nil {
+		return err
+	}
 	return fmt.Errorf("memcache: unexpected response line: %q", string(line))
 }
 
 // Delete deletes the item with the provided key. The error ErrCacheMiss is
 // returned if the item didn't already exist in the cache.
 func (c *Client) Delete(key string) error {
-	return c.withKeyRw(key, func(rw *bufio.ReadWriter) error {
-		return writeExpectf(rw, resultDeleted, "delete %s\r\n", key)
+	key = c.serverKey(key)
+	defer c.invalidateNear(key)
+	return c.retry("delete", func() error {
+		return c.withKeyRw(key, func(rw *bufio.ReadWriter) error {
+			return writeExpectf(rw, resultDeleted, "delete %s\r\n", key)
+		})
 	})
 }
 
-// DeleteAll deletes all items in the cache.
+// DeleteAll deletes all items in the cache, on every server. It is the
+// same as FlushAll.
 func (c *Client) DeleteAll() error {
-	return c.withKeyRw("", func(rw *bufio.ReadWriter) error {
-		return writeExpectf(rw, resultDeleted, "flush_all\r\n")
-	})
+	return c.FlushAll()
 }
 
 // Increment atomically increments key by delta. The return value is
@@ -1069,7 +1418,7 @@ func (c *Client) DeleteAll() error {
 // memcached must be an decimal number, or an error will be returned.
 // On 64-bit overflow, the new value wraps around.
 func (c *Client) Increment(key string, delta uint64) (newValue uint64, err error) {
-	return c.incrDecr("incr", key, delta)
+	return c.incrDecr("incr", c.serverKey(key), delta)
 }
 
 // Decrement atomically decrements key by delta. The return value is
@@ -1079,28 +1428,80 @@ func (c *Client) Increment(key string, delta uint64) (newValue uint64, err error
 // On underflow, the new value is capped at zero and does not wrap
 // around.
 func (c *Client) Decrement(key string, delta uint64) (newValue uint64, err error) {
-	return c.incrDecr("decr", key, delta)
+	return c.incrDecr("decr", c.serverKey(key), delta)
 }
 
 func (c *Client) incrDecr(verb, key string, delta uint64) (uint64, error) {
+	defer c.invalidateNear(key)
 	var val uint64
-	err := c.withKeyRw(key, func(rw *bufio.ReadWriter) error {
-		line, err := writeReadLine(rw, "%s %s %d\r\n", verb, key, delta)
-		if err != nil {
-			return err
-		}
-		switch {
-		case bytes.Equal(line, resultNotFound):
-			return ErrCacheMiss
-		case bytes.HasPrefix(line, resultClientErrorPrefix):
-			errMsg := line[len(resultClientErrorPrefix) : len(line)-2]
-			return errors.New("memcache: client error: " + string(errMsg))
-		}
-		val, err = strconv.ParseUint(string(line[:len(line)-2]), 10, 64)
-		if err != nil {
+	err := c.retry(verb, func() error {
+		return c.withKeyRw(key, func(rw *bufio.ReadWriter) error {
+			line, err := c.roundTrip(rw, []byte(fmt.Sprintf("%s %s %d\r\n", verb, key, delta)))
+			if err != nil {
+				return err
+			}
+			switch {
+			case bytes.Contains(line, resultNotFound):
+				return ErrCacheMiss
+			}
+			if err := responseError(verb, line); err != nil {
+				return err
+			}
+			val, err = strconv.ParseUint(string(trimLine(line)), 10, 64)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
+	})
+	return val, err
+}
+
+// IncrementWithInitial is like Increment, but if key isn't in the cache
+// it is created with the value initial and the given expiration instead
+// of failing with ErrCacheMiss. It uses the meta arithmetic command,
+// which needs memcached 1.6 or later.
+func (c *Client) IncrementWithInitial(key string, delta, initial uint64, expiration int32) (newValue uint64, err error) {
+	return c.metaArithmetic("incr", "I", c.serverKey(key), delta, initial, expiration)
+}
+
+// DecrementWithInitial is like Decrement, but creates a missing key as
+// IncrementWithInitial does.
+func (c *Client) DecrementWithInitial(key string, delta, initial uint64, expiration int32) (newValue uint64, err error) {
+	return c.metaArithmetic("decr", "D", c.serverKey(key), delta, initial, expiration)
+}
+
+var (
+	resultMetaValue    = []byte("VA ")
+	resultMetaNotFound = []byte("NF\r\n")
+)
+
+func (c *Client) metaArithmetic(verb, mode, key string, delta, initial uint64, expiration int32) (uint64, error) {
+	defer c.invalidateNear(key)
+	var val uint64
+	err := c.retry(verb, func() error {
+		return c.withKeyRw(key, func(rw *bufio.ReadWriter) error {
+			cmd := fmt.Sprintf("ma %s v M%s N%d J%d D%d\r\n", key, mode, expiration, initial, delta)
+			line, err := c.roundTrip(rw, []byte(cmd))
+			if err != nil {
+				return err
+			}
+			if err := responseError("ma", line); err != nil {
+				return err
+			}
+			switch {
+			case bytes.Contains(line, resultMetaNotFound):
+				return ErrCacheMiss
+			case !bytes.HasPrefix(trimLine(line), resultMetaValue):
+				return fmt.Errorf("memcache: unexpected response line from ma: %q", string(line))
+			}
+			line, err = rw.ReadSlice('\n')
+			if err != nil {
+				return err
+			}
+			val, err = strconv.ParseUint(string(trimLine(line)), 10, 64)
 			return err
-		}
-		return nil
+		})
 	})
 	return val, err
 }
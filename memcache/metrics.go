@@ -0,0 +1,85 @@
+package memcache
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Metrics is a snapshot of a Client's counters.
+type Metrics struct {
+	// ZoneRequests counts the requests served by each zone. It is only
+	// populated if the Client's selector implements ZoneReporter.
+	ZoneRequests map[string]uint64
+
+	// NearCacheHits and NearCacheMisses count the Gets answered from
+	// the near cache and those that went to a server.
+	NearCacheHits   uint64
+	NearCacheMisses uint64
+}
+
+// clientMetrics holds the live counters behind Metrics.
+type clientMetrics struct {
+	mu    sync.Mutex
+	zones map[string]uint64
+}
+
+func (m *clientMetrics) addZone(zone string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.zones == nil {
+		m.zones = make(map[string]uint64)
+	}
+	m.zones[zone]++
+}
+
+// Metrics returns a snapshot of the client's counters.
+func (c *Client) Metrics() Metrics {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+	m := Metrics{
+		NearCacheHits:   c.near.hits.Load(),
+		NearCacheMisses: c.near.misses.Load(),
+	}
+	if c.metrics.zones != nil {
+		m.ZoneRequests = make(map[string]uint64, len(c.metrics.zones))
+		for z, n := range c.metrics.zones {
+			m.ZoneRequests[z] = n
+		}
+	}
+	return m
+}
+
+// observe records the outcome of a request to addr that took d, and
+// passes it on to the selector if it is a HealthObserver.
+func (c *Client) observe(addr net.Addr, err error, d time.Duration) {
+	_, exhausted := err.(*PoolExhaustedError)
+	if c.Breaker != nil {
+		o := outcomeSuccess
+		switch {
+		case exhausted, err == errHedgeLost, err == ErrClientClosed:
+			o = outcomeNeutral
+		case !answeredError(err):
+			o = outcomeFailure
+		case errors.Is(err, ErrServerError):
+			o = outcomeFailure
+		case c.Breaker.SlowCall > 0 && d > c.Breaker.SlowCall:
+			o = outcomeFailure
+		}
+		c.pool(addr).breaker.record(c.Breaker, addr, time.Now(), o)
+	}
+	if exhausted || err == errHedgeLost || err == ErrClientClosed {
+		// The request never reached the server.
+		return
+	}
+	if ho, ok := c.selector.(HealthObserver); ok {
+		ho.ObserveResult(addr, err)
+	}
+	if !answeredError(err) {
+		return
+	}
+	if zr, ok := c.selector.(ZoneReporter); ok {
+		c.metrics.addZone(zr.ZoneOf(addr))
+	}
+}
@@ -0,0 +1,312 @@
+package memcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultNamespaceVersionTTL is how long a Namespace reuses the version
+// it last read when its VersionTTL is zero.
+const DefaultNamespaceVersionTTL = time.Second
+
+// Namespace is a view of a Client that keeps its keys apart from those
+// of other namespaces by prefixing them. The prefix also holds the
+// namespace's version, a number stored in the cache itself: Invalidate
+// replaces it, which makes every item of the namespace unreachable at once,
+// without flushing the servers for everybody else. The unreachable items
+// are left to expire or be evicted.
+//
+// Reading the version costs a round trip, so a Namespace reuses the
+// version it last read for VersionTTL. The version is stored in a form
+// that fits a client Layout with Pad set. With a Layout and no
+// KeyTransform, the namespace's keys, which are longer than the
+// Layout's KeyLen, are hashed to it with HashKey.
+// A Namespace is safe for concurrent use.
+type Namespace struct {
+	// VersionTTL is how long the version last read or set is reused
+	// before it is read again; an Invalidate by another client is seen
+	// up to VersionTTL late. If zero, DefaultNamespaceVersionTTL is used;
+	// if negative, the version is read for every operation.
+	VersionTTL time.Duration
+
+	c      *Client
+	prefix string
+
+	mu      sync.Mutex
+	version uint64
+	expires time.Time // when version must be read again
+}
+
+// Namespace returns the namespace with the given prefix. The keys of
+// its items are stored as "<prefix>:<version>:<key>", and its version
+// under "<prefix>:v".
+func (c *Client) Namespace(prefix string) *Namespace {
+	return &Namespace{c: c, prefix: prefix}
+}
+
+func (ns *Namespace) versionTTL() time.Duration {
+	if ns.VersionTTL != 0 {
+		return ns.VersionTTL
+	}
+	return DefaultNamespaceVersionTTL
+}
+
+func (ns *Namespace) versionKey() string {
+	return ns.fitKey(ns.prefix + ":v")
+}
+
+// fitKey hashes key to the KeyLen of the client's Layout, unless the
+// client transforms keys itself.
+func (ns *Namespace) fitKey(key string) string {
+	l := ns.c.Layout
+	if l == nil || ns.c.KeyTransform != nil {
+		return key
+	}
+	if n := l.keyLen(); n > 0 && n <= maxHashKeyLen && len(key) != n {
+		return HashKey(n)(key)
+	}
+	return key
+}
+
+// cachedVersion returns the version last read or set if it is still
+// fresh, and reads it otherwise.
+func (ns *Namespace) cachedVersion() (uint64, error) {
+	if ns.versionTTL() > 0 {
+		ns.mu.Lock()
+		v, expires := ns.version, ns.expires
+		ns.mu.Unlock()
+		if time.Now().Before(expires) {
+			return v, nil
+		}
+	}
+	return ns.Version()
+}
+
+// cacheVersion records v as the version last read or set.
+func (ns *Namespace) cacheVersion(v uint64) {
+	ttl := ns.versionTTL()
+	if ttl <= 0 {
+		return
+	}
+	ns.mu.Lock()
+	ns.version, ns.expires = v, time.Now().Add(ttl)
+	ns.mu.Unlock()
+}
+
+// namespaceVersionAttempts bounds the attempts Version makes at reading
+// or creating the version.
+const namespaceVersionAttempts = 3
+
+// ErrNamespaceVersion is returned when the version of a namespace can
+// neither be read nor created, e.g. because the server evicts it as soon
+// as it is stored.
+var ErrNamespaceVersion = errors.New("memcache: can't get namespace version")
+
+// Version reads the current version of the namespace, creating it if
+// it isn't in the cache. A new version is seeded from the clock, so
+// that a version that was evicted doesn't bring back the items stored
+// under it.
+func (ns *Namespace) Version() (uint64, error) {
+	var buf [32]byte
+	for i := 0; i < namespaceVersionAttempts; i++ {
+		it, err := ns.c.GetInto(ns.versionKey(), buf[:])
+		if err == nil {
+			v, err := ns.parseVersion(it.Value)
+			if err == nil {
+				ns.cacheVersion(v)
+			}
+			return v, err
+		}
+		if err != ErrCacheMiss {
+			return 0, err
+		}
+		v := uint64(time.Now().UnixNano())
+		err = ns.c.Add(ns.versionItem(v))
+		if err == nil {
+			ns.cacheVersion(v)
+			return v, nil
+		}
+		if err != ErrNotStored {
+			return 0, err
+		}
+		// Another client created the version first; read it.
+	}
+	return 0, ErrNamespaceVersion
+}
+
+// Invalidate replaces the version of the namespace with a new one, so
+// that none of the items stored before can be got anymore.
+func (ns *Namespace) Invalidate() error {
+	v, err := ns.Version()
+	if err != nil {
+		return err
+	}
+	nv := uint64(time.Now().UnixNano())
+	if nv <= v {
+		nv = v + 1
+	}
+	if err := ns.c.Set(ns.versionItem(nv)); err != nil {
+		return err
+	}
+	ns.cacheVersion(nv)
+	return nil
+}
+
+// versionItem returns the item holding version v. It carries a JSON
+// prefix if the client's Layout requires one.
+func (ns *Namespace) versionItem(v uint64) *Item {
+	value := strconv.AppendUint(nil, v, 10)
+	if l := ns.c.Layout; l != nil && l.JSONPrefix {
+		prefixed := make([]byte, jsonPrefixLen, jsonPrefixLen+len(value))
+		binary.LittleEndian.PutUint16(prefixed, uint16(len(value)))
+		value = append(prefixed, value...)
+	}
+	return &Item{Key: ns.versionKey(), Value: value}
+}
+
+// parseVersion parses a value stored by versionItem, ignoring the
+// padding and JSON prefix the client's Layout may have added.
+func (ns *Namespace) parseVersion(value []byte) (uint64, error) {
+	if l := ns.c.Layout; l != nil && l.JSONPrefix && len(value) >= jsonPrefixLen {
+		value = value[jsonPrefixLen:]
+	}
+	return strconv.ParseUint(string(bytes.TrimRight(value, "\x00")), 10, 64)
+}
+
+// key returns the key under which the namespace stores key.
+func (ns *Namespace) key(key string) (string, error) {
+	v, err := ns.cachedVersion()
+	if err != nil {
+		return "", err
+	}
+	return ns.keyAt(v, key), nil
+}
+
+// keyAt returns the key under which version v of the namespace stores
+// key.
+func (ns *Namespace) keyAt(v uint64, key string) string {
+	return ns.fitKey(ns.prefix + ":" + strconv.FormatUint(v, 10) + ":" + key)
+}
+
+// item returns a copy of item with the key the namespace stores it
+// under.
+func (ns *Namespace) item(item *Item) (*Item, error) {
+	key, err := ns.key(item.Key)
+	if err != nil {
+		return nil, err
+	}
+	it := *item
+	it.Key = key
+	return &it, nil
+}
+
+// Get is Client.Get within the namespace.
+func (ns *Namespace) Get(key string, scancount int) (*Item, error) {
+	nkey, err := ns.key(key)
+	if err != nil {
+		return nil, err
+	}
+	it, err := ns.c.Get(nkey, scancount)
+	return clientItem(it, key), err
+}
+
+// GetInto is Client.GetInto within the namespace.
+func (ns *Namespace) GetInto(key string, buf []byte) (*Item, error) {
+	nkey, err := ns.key(key)
+	if err != nil {
+		return nil, err
+	}
+	it, err := ns.c.GetInto(nkey, buf)
+	return clientItem(it, key), err
+}
+
+// GetMulti is Client.GetMulti within the namespace.
+func (ns *Namespace) GetMulti(keys []string) (map[string]*Item, error) {
+	v, err := ns.cachedVersion()
+	if err != nil {
+		return nil, err
+	}
+	nkeys := make([]string, len(keys))
+	for i, key := range keys {
+		nkeys[i] = ns.keyAt(v, key)
+	}
+	m, err := ns.c.GetMulti(nkeys)
+	if m == nil {
+		return nil, err
+	}
+	items := make(map[string]*Item, len(m))
+	for i, nkey := range nkeys {
+		if it, ok := m[nkey]; ok {
+			items[keys[i]] = clientItem(it, keys[i])
+		}
+	}
+	return items, err
+}
+
+// Set is Client.Set within the namespace.
+func (ns *Namespace) Set(item *Item) error {
+	return ns.store(item, ns.c.Set)
+}
+
+// Add is Client.Add within the namespace.
+func (ns *Namespace) Add(item *Item) error {
+	return ns.store(item, ns.c.Add)
+}
+
+// Replace is Client.Replace within the namespace.
+func (ns *Namespace) Replace(item *Item) error {
+	return ns.store(item, ns.c.Replace)
+}
+
+// CompareAndSwap is Client.CompareAndSwap within the namespace.
+func (ns *Namespace) CompareAndSwap(item *Item) error {
+	return ns.store(item, ns.c.CompareAndSwap)
+}
+
+func (ns *Namespace) store(item *Item, fn func(*Item) error) error {
+	it, err := ns.item(item)
+	if err != nil {
+		return err
+	}
+	return fn(it)
+}
+
+// Delete is Client.Delete within the namespace.
+func (ns *Namespace) Delete(key string) error {
+	nkey, err := ns.key(key)
+	if err != nil {
+		return err
+	}
+	return ns.c.Delete(nkey)
+}
+
+// Touch is Client.Touch within the namespace.
+func (ns *Namespace) Touch(key string, seconds int32) error {
+	nkey, err := ns.key(key)
+	if err != nil {
+		return err
+	}
+	return ns.c.Touch(nkey, seconds)
+}
+
+// Increment is Client.Increment within the namespace.
+func (ns *Namespace) Increment(key string, delta uint64) (uint64, error) {
+	nkey, err := ns.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return ns.c.Increment(nkey, delta)
+}
+
+// Decrement is Client.Decrement within the namespace.
+func (ns *Namespace) Decrement(key string, delta uint64) (uint64, error) {
+	nkey, err := ns.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return ns.c.Decrement(nkey, delta)
+}
@@ -0,0 +1,117 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNamespace(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		a, b := c.Namespace("a"), c.Namespace("b")
+		b.VersionTTL = -1 // see the eviction below
+		if err := a.Set(&Item{Key: "k", Value: []byte("in a")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := b.Set(&Item{Key: "k", Value: []byte("in b")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		it, err := a.GetInto("k", nil)
+		if err != nil || it.Key != "k" || string(it.Value) != "in a" {
+			t.Fatalf("a.GetInto = %+v, %v", it, err)
+		}
+
+		v, err := a.Version()
+		if err != nil {
+			t.Fatalf("Version: %v", err)
+		}
+		if err := a.Invalidate(); err != nil {
+			t.Fatalf("Invalidate: %v", err)
+		}
+		if nv, err := a.Version(); err != nil || nv <= v {
+			t.Errorf("Version after Invalidate = %d, %v; want more than %d", nv, err, v)
+		}
+		if _, err := a.GetInto("k", nil); err != ErrCacheMiss {
+			t.Errorf("a.GetInto after Invalidate: got %v, want ErrCacheMiss", err)
+		}
+		if it, err := b.GetInto("k", nil); err != nil || string(it.Value) != "in b" {
+			t.Errorf("b.GetInto after a.Invalidate = %+v, %v", it, err)
+		}
+
+		// An evicted version is replaced by a newer one.
+		fm.mu.Lock()
+		delete(fm.items, "b:v")
+		fm.mu.Unlock()
+		if _, err := b.GetInto("k", nil); err != ErrCacheMiss {
+			t.Errorf("b.GetInto after its version was evicted: got %v, want ErrCacheMiss", err)
+		}
+	})
+}
+
+func TestNamespaceLayout(t *testing.T) {
+	for _, l := range []*FPGALayout{{Pad: true}, {Pad: true, KeyLen: -1}, {Pad: true, JSONPrefix: true, KeyLen: -1}} {
+		forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+			c.Layout = l
+			ns := c.Namespace("ns")
+			v, err := ns.Version()
+			if err != nil {
+				t.Fatalf("Version with %+v: %v", *l, err)
+			}
+			if got, err := ns.Version(); err != nil || got != v {
+				t.Errorf("Version read back with %+v = %d, %v; want %d", *l, got, err, v)
+			}
+			if err := ns.Invalidate(); err != nil {
+				t.Fatalf("Invalidate with %+v: %v", *l, err)
+			}
+			if got, err := ns.Version(); err != nil || got <= v {
+				t.Errorf("Version after Invalidate with %+v = %d, %v; want more than %d", *l, got, err, v)
+			}
+			if err := ns.Set(&Item{Key: "k", Value: make([]byte, 56)}); err != nil {
+				t.Errorf("Set with %+v: %v", *l, err)
+			}
+		})
+	}
+}
+
+func TestNamespaceVersionCache(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		ns := c.Namespace("ns")
+		ns.VersionTTL = 50 * time.Millisecond
+		if err := ns.Set(&Item{Key: "k", Value: []byte("v")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+
+		// Until the TTL runs out, the version isn't read again: the
+		// items stay reachable though the version was evicted.
+		fm.mu.Lock()
+		delete(fm.items, "ns:v")
+		fm.mu.Unlock()
+		if it, err := ns.GetInto("k", nil); err != nil || string(it.Value) != "v" {
+			t.Errorf("GetInto with a cached version = %+v, %v", it, err)
+		}
+		time.Sleep(2 * ns.VersionTTL)
+		if _, err := ns.GetInto("k", nil); err != ErrCacheMiss {
+			t.Errorf("GetInto after the TTL: got %v, want ErrCacheMiss", err)
+		}
+	})
+}
+
+func TestNamespaceVersionUnstorable(t *testing.T) {
+	// The server never keeps the version.
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		switch strings.Fields(line)[0] {
+		case "get":
+			w.WriteString("END\r\n")
+		case "add":
+			r.ReadString('\n')
+			w.WriteString("NOT_STORED\r\n")
+		}
+	})
+	c := New(s.Addr())
+	c.Timeout = time.Second
+	if _, err := c.Namespace("ns").Version(); !errors.Is(err, ErrNamespaceVersion) {
+		t.Errorf("Version: got %v, want ErrNamespaceVersion", err)
+	}
+}
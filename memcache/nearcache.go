@@ -0,0 +1,189 @@
+package memcache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultNearCacheBytes is the default size of the near cache.
+	DefaultNearCacheBytes = 64 << 20
+
+	// DefaultNearCacheTTL is the default time an item stays in the near
+	// cache.
+	DefaultNearCacheTTL = time.Second
+)
+
+// NearCachePolicy configures an in-process LRU cache of the items got
+// with Get, in front of the servers, which saves round trips for the
+// hottest keys.
+//
+// The near cache only learns of the changes made through the same
+// Client: its items are invalidated by the client's storage commands,
+// deletes, increments, decrements and flushes. Changes made by other
+// clients show after at most TTL. Other reads, such as GetMulti and
+// Ret, don't use the near cache.
+//
+// Only Gets with a scancount of 1 use the near cache; scans go to the
+// servers as if there was none. On a miss, Get reads the item's value as
+// GetInto does, without hedging or coalescing. The items returned from
+// the near cache share their Value with it, so callers must not modify
+// it.
+type NearCachePolicy struct {
+	// MaxBytes bounds the keys and values held in the near cache; the
+	// least recently used items are evicted to make room for new ones.
+	// If zero, DefaultNearCacheBytes is used.
+	MaxBytes int64
+
+	// TTL is how long an item stays in the near cache. If zero,
+	// DefaultNearCacheTTL is used.
+	TTL time.Duration
+}
+
+func (p *NearCachePolicy) maxBytes() int64 {
+	if p.MaxBytes > 0 {
+		return p.MaxBytes
+	}
+	return DefaultNearCacheBytes
+}
+
+func (p *NearCachePolicy) ttl() time.Duration {
+	if p.TTL > 0 {
+		return p.TTL
+	}
+	return DefaultNearCacheTTL
+}
+
+// nearEntry is an item in the near cache.
+type nearEntry struct {
+	key     string // key sent to the servers
+	item    Item
+	size    int64
+	expires time.Time
+}
+
+// nearCache is the LRU cache behind NearCachePolicy. Its items are
+// keyed by the keys sent to the servers.
+type nearCache struct {
+	mu      sync.Mutex
+	ll      *list.List // of *nearEntry, most recently used first
+	entries map[string]*list.Element
+	size    int64
+	gen     uint64 // bumped by every invalidation
+
+	hits, misses atomic.Uint64
+}
+
+// get returns a copy of the item cached under key, if any.
+func (nc *nearCache) get(key string, now time.Time) (*Item, bool) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	e, ok := nc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	ent := e.Value.(*nearEntry)
+	if !now.Before(ent.expires) {
+		nc.remove(e)
+		return nil, false
+	}
+	nc.ll.MoveToFront(e)
+	it := ent.item
+	return &it, true
+}
+
+// generation returns the number of invalidations so far. An item read
+// from a server is only cached if no invalidation happened while it was
+// being read, since it may be older than the invalidation.
+func (nc *nearCache) generation() uint64 {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return nc.gen
+}
+
+// add caches a copy of item under key, unless an invalidation happened
+// since generation gen.
+func (nc *nearCache) add(p *NearCachePolicy, key string, item *Item, gen uint64, now time.Time) {
+	size := int64(len(key) + len(item.Value))
+	max := p.maxBytes()
+	if size > max {
+		return
+	}
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.gen != gen {
+		return
+	}
+	if nc.entries == nil {
+		nc.ll = list.New()
+		nc.entries = make(map[string]*list.Element)
+	}
+	if e, ok := nc.entries[key]; ok {
+		nc.remove(e)
+	}
+	for nc.size+size > max {
+		nc.remove(nc.ll.Back())
+	}
+	ent := &nearEntry{key: key, item: *item, size: size, expires: now.Add(p.ttl())}
+	nc.entries[key] = nc.ll.PushFront(ent)
+	nc.size += size
+}
+
+// remove drops e from the cache. nc.mu must be held.
+func (nc *nearCache) remove(e *list.Element) {
+	ent := nc.ll.Remove(e).(*nearEntry)
+	delete(nc.entries, ent.key)
+	nc.size -= ent.size
+}
+
+// invalidate drops the items cached under keys.
+func (nc *nearCache) invalidate(keys ...string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.gen++
+	for _, key := range keys {
+		if e, ok := nc.entries[key]; ok {
+			nc.remove(e)
+		}
+	}
+}
+
+// purge drops every cached item.
+func (nc *nearCache) purge() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.gen++
+	nc.ll, nc.entries, nc.size = nil, nil, 0
+}
+
+// invalidateNear drops the items cached under the server keys keys
+// from the near cache, if the client has one.
+func (c *Client) invalidateNear(keys ...string) {
+	if c.NearCache != nil {
+		c.near.invalidate(keys...)
+	}
+}
+
+// nearGet is Get through the near cache. skey is the key sent to the
+// servers for key.
+func (c *Client) nearGet(key, skey string) (*Item, error) {
+	now := time.Now()
+	if it, ok := c.near.get(skey, now); ok {
+		c.near.hits.Add(1)
+		return clientItem(it, key), nil
+	}
+	c.near.misses.Add(1)
+	gen := c.near.generation()
+	it, err := c.GetInto(key, nil)
+	if err == ErrCacheMiss {
+		// Get reports misses with a nil item.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.near.add(c.NearCache, skey, it, gen, now)
+	return it, nil
+}
@@ -0,0 +1,137 @@
+package memcache
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNearCache(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		c.NearCache = &NearCachePolicy{TTL: time.Hour}
+		if err := c.Set(&Item{Key: "k", Value: []byte("v1")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			it, err := c.Get("k", 1)
+			if err != nil || it == nil || it.Key != "k" || string(it.Value) != "v1" {
+				t.Fatalf("Get #%d = %+v, %v", i, it, err)
+			}
+		}
+		if m := c.Metrics(); m.NearCacheHits != 1 || m.NearCacheMisses != 1 {
+			t.Errorf("hits, misses = %d, %d; want 1, 1", m.NearCacheHits, m.NearCacheMisses)
+		}
+
+		// Changes made by others aren't seen, local ones are.
+		fm.exec("set k 0 0 2", []byte("v2"))
+		if it, _ := c.Get("k", 1); string(it.Value) != "v1" {
+			t.Errorf("Get after remote change = %q, want the cached v1", it.Value)
+		}
+		if err := c.Set(&Item{Key: "k", Value: []byte("v3")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if it, _ := c.Get("k", 1); string(it.Value) != "v3" {
+			t.Errorf("Get after Set = %q, want v3", it.Value)
+		}
+		if _, err := c.DeleteMulti([]string{"k"}); err != nil {
+			t.Fatalf("DeleteMulti: %v", err)
+		}
+		if it, err := c.Get("k", 1); it != nil || err != nil {
+			t.Errorf("Get after DeleteMulti = %+v, %v; want a miss", it, err)
+		}
+
+		if err := c.Set(&Item{Key: "n", Value: []byte("1")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		c.Get("n", 1)
+		if _, err := c.Increment("n", 1); err != nil {
+			t.Fatalf("Increment: %v", err)
+		}
+		if it, _ := c.Get("n", 1); string(it.Value) != "2" {
+			t.Errorf("Get after Increment = %q, want 2", it.Value)
+		}
+		if err := c.FlushAll(); err != nil {
+			t.Fatalf("FlushAll: %v", err)
+		}
+		if it, _ := c.Get("n", 1); it != nil {
+			t.Errorf("Get after FlushAll = %+v, want a miss", it)
+		}
+	})
+}
+
+func TestNearCacheTTL(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		c.NearCache = &NearCachePolicy{TTL: 20 * time.Millisecond}
+		if err := c.Set(&Item{Key: "k", Value: []byte("v1")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		c.Get("k", 1)
+		fm.exec("set k 0 0 2", []byte("v2"))
+		time.Sleep(30 * time.Millisecond)
+		if it, _ := c.Get("k", 1); it == nil || string(it.Value) != "v2" {
+			t.Errorf("Get after TTL = %+v, want v2", it)
+		}
+	})
+}
+
+func TestNearCacheEviction(t *testing.T) {
+	var nc nearCache
+	p := &NearCachePolicy{MaxBytes: 10, TTL: time.Hour}
+	now := time.Now()
+	for _, key := range []string{"a", "b", "c"} {
+		nc.add(p, key, &Item{Key: key, Value: []byte("1234")}, nc.generation(), now)
+		if key == "b" {
+			nc.get("a", now) // a is now more recently used than b
+		}
+	}
+	if _, ok := nc.get("b", now); ok {
+		t.Errorf("least recently used item wasn't evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := nc.get(key, now); !ok {
+			t.Errorf("item %q was evicted", key)
+		}
+	}
+	if nc.size != 10 {
+		t.Errorf("size = %d, want 10", nc.size)
+	}
+
+	nc.add(p, "big", &Item{Value: make([]byte, 20)}, nc.generation(), now)
+	if _, ok := nc.get("big", now); ok {
+		t.Errorf("item larger than MaxBytes was cached")
+	}
+
+	// An item read before an invalidation isn't cached.
+	gen := nc.generation()
+	nc.invalidate("d")
+	nc.add(p, "d", &Item{Value: []byte("x")}, gen, now)
+	if _, ok := nc.get("d", now); ok {
+		t.Errorf("item read before an invalidation was cached")
+	}
+}
+
+func TestNearCacheScan(t *testing.T) {
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		switch strings.Fields(line)[0] {
+		case "get":
+			w.WriteString("END\r\nEND\r\nEND\r\n")
+		case "set":
+			r.ReadString('\n')
+			w.WriteString("STORED\r\n")
+		}
+	})
+	c := New(s.Addr())
+	c.Timeout = time.Second
+	c.NearCache = &NearCachePolicy{}
+
+	if it, err := c.Get("scan", 3); it != nil || err != nil {
+		t.Fatalf("Get = %+v, %v; want a miss", it, err)
+	}
+	if err := c.Set(&Item{Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatalf("Set after a scan: %v", err)
+	}
+	if m := c.Metrics(); m.NearCacheHits != 0 || m.NearCacheMisses != 0 {
+		t.Errorf("scan went through the near cache: hits, misses = %d, %d", m.NearCacheHits, m.NearCacheMisses)
+	}
+}
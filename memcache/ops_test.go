@@ -0,0 +1,117 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAppendPrepend(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		if err := c.Append(&Item{Key: "missing", Value: []byte("x")}); err != ErrNotStored {
+			t.Errorf("Append to missing key: got %v, want ErrNotStored", err)
+		}
+		if err := c.Set(&Item{Key: "k", Value: []byte("b")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := c.Append(&Item{Key: "k", Value: []byte("cd")}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if err := c.Prepend(&Item{Key: "k", Value: []byte("a")}); err != nil {
+			t.Fatalf("Prepend: %v", err)
+		}
+		if it, _ := fm.item("k"); string(it.value) != "abcd" {
+			t.Errorf("value = %q, want abcd", it.value)
+		}
+	})
+}
+
+func TestGetAndTouch(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		if _, err := c.GetAndTouch("k", 100); err != ErrCacheMiss {
+			t.Errorf("GetAndTouch of missing key: got %v, want ErrCacheMiss", err)
+		}
+		if err := c.Set(&Item{Key: "k", Value: []byte("hello"), Flags: 7, Expiration: 10}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		it, err := c.GetAndTouch("k", 100)
+		if err != nil {
+			t.Fatalf("GetAndTouch: %v", err)
+		}
+		if it.Key != "k" || string(it.Value) != "hello" || it.Flags != 7 {
+			t.Errorf("GetAndTouch = %+v", it)
+		}
+		if fi, _ := fm.item("k"); fi.exp != 100 {
+			t.Errorf("expiration = %d, want 100", fi.exp)
+		}
+
+		// The item carries its CAS id.
+		it.Value = []byte("world")
+		if err := c.CompareAndSwap(it); err != nil {
+			t.Fatalf("CompareAndSwap: %v", err)
+		}
+		if err := c.CompareAndSwap(it); err != ErrCASConflict {
+			t.Errorf("second CompareAndSwap: got %v, want ErrCASConflict", err)
+		}
+	})
+}
+
+func TestTouchProtocols(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		if err := c.Touch("k", 50); err != ErrCacheMiss {
+			t.Errorf("Touch of missing key: got %v, want ErrCacheMiss", err)
+		}
+		if err := c.Set(&Item{Key: "k", Value: []byte("v")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := c.Touch("k", 50); err != nil {
+			t.Fatalf("Touch: %v", err)
+		}
+		if fi, _ := fm.item("k"); fi.exp != 50 {
+			t.Errorf("expiration = %d, want 50", fi.exp)
+		}
+	})
+}
+
+func TestIncrDecrProtocols(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		if _, err := c.Increment("n", 1); err != ErrCacheMiss {
+			t.Errorf("Increment of missing key: got %v, want ErrCacheMiss", err)
+		}
+		if err := c.Set(&Item{Key: "n", Value: []byte("5")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if v, err := c.Increment("n", 3); err != nil || v != 8 {
+			t.Errorf("Increment = %d, %v; want 8", v, err)
+		}
+		if v, err := c.Decrement("n", 10); err != nil || v != 0 {
+			t.Errorf("Decrement = %d, %v; want 0", v, err)
+		}
+
+		if err := c.Set(&Item{Key: "s", Value: []byte("abc")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if _, err := c.Increment("s", 1); !errors.Is(err, ErrClientError) {
+			t.Errorf("Increment of non-numeric value: got %v, want ErrClientError", err)
+		}
+	})
+}
+
+func TestIncrDecrWithInitial(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		if v, err := c.IncrementWithInitial("n", 5, 10, 60); err != nil || v != 10 {
+			t.Fatalf("IncrementWithInitial of missing key = %d, %v; want 10", v, err)
+		}
+		if fi, _ := fm.item("n"); fi.exp != 60 {
+			t.Errorf("expiration = %d, want 60", fi.exp)
+		}
+		if v, err := c.IncrementWithInitial("n", 5, 10, 60); err != nil || v != 15 {
+			t.Errorf("IncrementWithInitial = %d, %v; want 15", v, err)
+		}
+		if v, err := c.DecrementWithInitial("n", 20, 10, 60); err != nil || v != 0 {
+			t.Errorf("DecrementWithInitial = %d, %v; want 0", v, err)
+		}
+		if v, err := c.DecrementWithInitial("m", 1, 7, 0); err != nil || v != 7 {
+			t.Errorf("DecrementWithInitial of missing key = %d, %v; want 7", v, err)
+		}
+	})
+}
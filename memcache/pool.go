@@ -0,0 +1,446 @@
+package memcache
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// PoolExhaustedError is the error type used when MaxOpenConns
+// connections to Addr are in use and none was released in time.
+type PoolExhaustedError struct {
+	Addr         net.Addr
+	MaxOpenConns int
+}
+
+func (pe *PoolExhaustedError) Error() string {
+	return fmt.Sprintf("memcache: all %d connections to %s in use", pe.MaxOpenConns, pe.Addr)
+}
+
+// addrPool holds the connections to a single server address. Every
+// address has its own lock, so requests to different servers don't
+// contend with each other; requests to the same server still do.
+type addrPool struct {
+	c    *Client
+	addr net.Addr
+
+	mu      sync.Mutex
+	free    []*conn      // idle connections, most recently used last
+	numOpen int          // idle, in use and being dialed
+	reqs    []chan *conn // requests waiting for a connection, oldest first
+
+	breaker   breaker
+	coalescer coalescer
+
+	removed bool // the server left the selector; p.mu guards it
+}
+
+// pool returns the pool for addr, creating it on first use.
+//
+// Pools are looked up without locking by the net.Addr the selector
+// returns, which for the selectors in this package is a pointer that
+// stays the same until the servers change. The first time a new
+// pointer is seen it is matched by name, so that an address keeps its
+// pool across SetServers calls, and the pools of the servers that left
+// the selector are dropped.
+func (c *Client) pool(addr net.Addr) *addrPool {
+	comparable := comparableAddr(addr)
+	if comparable {
+		if p, ok := c.pools.Load(addr); ok {
+			return p.(*addrPool)
+		}
+	}
+	c.lk.Lock()
+	var removed []*addrPool
+	if comparable {
+		// A new pointer means the servers may have changed.
+		removed = c.prunePools()
+	}
+	if c.poolsByName == nil {
+		c.poolsByName = make(map[string]*addrPool)
+	}
+	p, ok := c.poolsByName[addr.String()]
+	if !ok {
+		p = &addrPool{c: c, addr: addr}
+		c.poolsByName[addr.String()] = p
+	}
+	if comparable {
+		c.pools.Store(addr, p)
+	}
+	c.lk.Unlock()
+
+	for _, rp := range removed {
+		rp.remove()
+	}
+	return p
+}
+
+// comparableAddr reports whether addr can be used as a key of
+// Client.pools.
+func comparableAddr(addr net.Addr) bool {
+	if _, ok := addr.(*staticAddr); ok {
+		return true
+	}
+	return reflect.TypeOf(addr).Comparable()
+}
+
+// prunePools forgets the addresses the selector no longer returns, and
+// returns the pools of the servers that are gone. c.lk must be held.
+func (c *Client) prunePools() []*addrPool {
+	if c.selector == nil {
+		return nil
+	}
+	names := make(map[string]bool)
+	addrs := make(map[net.Addr]bool)
+	err := c.selector.Each(func(addr net.Addr) error {
+		names[addr.String()] = true
+		if comparableAddr(addr) {
+			addrs[addr] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+	c.pools.Range(func(k, _ interface{}) bool {
+		if !addrs[k.(net.Addr)] {
+			c.pools.Delete(k)
+		}
+		return true
+	})
+	var removed []*addrPool
+	for name, p := range c.poolsByName {
+		if !names[name] {
+			delete(c.poolsByName, name)
+			removed = append(removed, p)
+		}
+	}
+	return removed
+}
+
+// remove closes the idle connections of a pool whose server left the
+// selector. The connections in use are closed when they are put back.
+func (p *addrPool) remove() {
+	p.mu.Lock()
+	p.removed = true
+	p.mu.Unlock()
+	p.closeIdle()
+}
+
+// put returns cn to the pool, handing it straight to the oldest waiting
+// request if there is one.
+func (p *addrPool) put(cn *conn) {
+	p.mu.Lock()
+	if req, ok := p.popReq(); ok {
+		req <- cn
+		p.mu.Unlock()
+		return
+	}
+	c := p.c
+	reaped := c.ConnMaxIdleTime > 0 || c.ConnMaxLifetime > 0
+	if reaped {
+		cn.idleSince = time.Now()
+	}
+	if len(p.free) >= c.maxIdleConns() || (reaped && cn.expired(cn.idleSince)) || c.closed.Load() || p.removed {
+		cn.closeOnce.Do(func() {
+			cn.nc.Close()
+			p.numOpen--
+		})
+		p.mu.Unlock()
+		return
+	}
+	p.free = append(p.free, cn)
+	p.mu.Unlock()
+
+	if reaped {
+		c.startReaper()
+	}
+}
+
+// get returns an idle connection. Connections that have expired or,
+// with CheckConnLiveness, turn out to be dead are closed and skipped.
+func (p *addrPool) get() (cn *conn, ok bool) {
+	c := p.c
+	reaped := c.ConnMaxIdleTime > 0 || c.ConnMaxLifetime > 0
+	for {
+		cn, ok = p.pop()
+		if !ok {
+			return nil, false
+		}
+		if (reaped && cn.expired(time.Now())) || (c.CheckConnLiveness && !cn.alive()) {
+			cn.close()
+			continue
+		}
+		return cn, true
+	}
+}
+
+func (p *addrPool) pop() (cn *conn, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.free) == 0 {
+		return nil, false
+	}
+	cn = p.free[len(p.free)-1]
+	p.free[len(p.free)-1] = nil
+	p.free = p.free[:len(p.free)-1]
+	return cn, true
+}
+
+// popReq removes and returns the oldest request waiting for a
+// connection. p.mu must be held.
+func (p *addrPool) popReq() (chan *conn, bool) {
+	if len(p.reqs) == 0 {
+		return nil, false
+	}
+	req := p.reqs[0]
+	p.reqs[0] = nil
+	p.reqs = p.reqs[1:]
+	return req, true
+}
+
+// closed gives up the slot of a closed connection. If a request is
+// waiting, the slot is handed over to it instead.
+func (p *addrPool) closed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if req, ok := p.popReq(); ok {
+		req <- nil
+		return
+	}
+	p.numOpen--
+}
+
+// reserve reserves a slot for a new connection. If MaxOpenConns
+// connections are already open, it waits until one is released or
+// closed, for at most the client's timeout. A
+// released connection is handed over and returned; otherwise the
+// caller must dial, and call closed if dialing fails.
+func (p *addrPool) reserve() (*conn, error) {
+	max := p.c.MaxOpenConns
+	p.mu.Lock()
+	if max <= 0 || p.numOpen < max {
+		p.numOpen++
+		p.mu.Unlock()
+		return nil, nil
+	}
+	req := make(chan *conn, 1)
+	p.reqs = append(p.reqs, req)
+	p.mu.Unlock()
+
+	t := time.NewTimer(p.c.netTimeout())
+	defer t.Stop()
+	select {
+	case cn := <-req:
+		return cn, nil
+	case <-t.C:
+	}
+
+	p.mu.Lock()
+	for i, r := range p.reqs {
+		if r == req {
+			p.reqs = append(p.reqs[:i:i], p.reqs[i+1:]...)
+			p.mu.Unlock()
+			return nil, &PoolExhaustedError{Addr: p.addr, MaxOpenConns: max}
+		}
+	}
+	p.mu.Unlock()
+	// The request was served while waiting timed out.
+	return <-req, nil
+}
+
+// reap removes the idle connections that have expired by now and
+// returns them, along with the number of idle connections left.
+func (p *addrPool) reap(now time.Time) (stale []*conn, idle int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fresh := p.free[:0]
+	for _, cn := range p.free {
+		if cn.expired(now) {
+			stale = append(stale, cn)
+		} else {
+			fresh = append(fresh, cn)
+		}
+	}
+	for i := len(fresh); i < len(p.free); i++ {
+		p.free[i] = nil
+	}
+	p.free = fresh
+	return stale, len(fresh)
+}
+
+// startReaper starts reapConns unless it is already running.
+func (c *Client) startReaper() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if !c.reaping {
+		c.reaping = true
+		go c.reapConns()
+	}
+}
+
+// reapConns periodically closes idle connections that have exceeded
+// ConnMaxIdleTime or ConnMaxLifetime. It returns once no pool holds an
+// idle connection, and is restarted when one is put back.
+func (c *Client) reapConns() {
+	for {
+		time.Sleep(c.reapInterval())
+
+		c.lk.Lock()
+		pools := make([]*addrPool, 0, len(c.poolsByName))
+		for _, p := range c.poolsByName {
+			pools = append(pools, p)
+		}
+		c.lk.Unlock()
+
+		var stale []*conn
+		idle := 0
+		now := time.Now()
+		for _, p := range pools {
+			s, n := p.reap(now)
+			stale = append(stale, s...)
+			idle += n
+		}
+		for _, cn := range stale {
+			cn.close()
+		}
+		if idle > 0 {
+			continue
+		}
+
+		c.lk.Lock()
+		// A connection put back since the scan would have found
+		// the reaper still running; check again before stopping.
+		for _, p := range c.poolsByName {
+			p.mu.Lock()
+			idle += len(p.free)
+			p.mu.Unlock()
+		}
+		if idle == 0 {
+			c.reaping = false
+		}
+		c.lk.Unlock()
+		if idle == 0 {
+			return
+		}
+	}
+}
+
+// reapInterval returns the time between two runs of reapConns.
+func (c *Client) reapInterval() time.Duration {
+	d := c.ConnMaxIdleTime
+	if d <= 0 || (c.ConnMaxLifetime > 0 && c.ConnMaxLifetime < d) {
+		d = c.ConnMaxLifetime
+	}
+	if d /= 2; d <= 0 {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// closeIdle closes the idle connections of every pool.
+func (p *addrPool) closeIdle() {
+	p.mu.Lock()
+	free := p.free
+	p.free = nil
+	p.mu.Unlock()
+	for _, cn := range free {
+		cn.close()
+	}
+}
+
+// enter starts a request that will check out a connection, and returns
+// false if the client has been closed.
+func (c *Client) enter() bool {
+	c.inflight.Add(1)
+	if c.closed.Load() {
+		c.exit()
+		return false
+	}
+	return true
+}
+
+// exit ends a request started with enter.
+func (c *Client) exit() {
+	if c.inflight.Add(-1) == 0 && c.closed.Load() {
+		c.lk.Lock()
+		if c.drained != nil {
+			c.drained.Broadcast()
+		}
+		c.lk.Unlock()
+	}
+}
+
+// Warmup makes sure that n idle connections to every server of the
+// client's selector are in the pool, dialing new ones as needed, so
+// that the first requests don't pay for connection setup. n is capped
+// at MaxIdleConns and, if set, MaxOpenConns.
+func (c *Client) Warmup(n int) error {
+	if n > c.maxIdleConns() {
+		n = c.maxIdleConns()
+	}
+	if c.MaxOpenConns > 0 && n > c.MaxOpenConns {
+		n = c.MaxOpenConns
+	}
+	// The addresses are collected first: getConn may look at the
+	// selector's servers, which Each holds locked.
+	addrs, err := c.servers()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if err := c.warmup(addr, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warmup makes sure that n idle connections to addr are in the pool.
+func (c *Client) warmup(addr net.Addr, n int) error {
+	cns := make([]*conn, 0, n)
+	defer func() {
+		for _, cn := range cns {
+			cn.release()
+		}
+	}()
+	for len(cns) < n {
+		cn, err := c.getConn(addr)
+		if err != nil {
+			return err
+		}
+		cns = append(cns, cn)
+	}
+	return nil
+}
+
+// Close closes the client's idle connections, waits for requests in
+// flight to finish and closes their connections too. Requests made
+// after Close fail with ErrClientClosed. Close is safe to call more
+// than once.
+func (c *Client) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	c.lk.Lock()
+	pools := make([]*addrPool, 0, len(c.poolsByName))
+	for _, p := range c.poolsByName {
+		pools = append(pools, p)
+	}
+	c.lk.Unlock()
+	for _, p := range pools {
+		p.closeIdle()
+	}
+
+	c.lk.Lock()
+	if c.drained == nil {
+		c.drained = sync.NewCond(&c.lk)
+	}
+	for c.inflight.Load() > 0 {
+		c.drained.Wait()
+	}
+	c.lk.Unlock()
+	return nil
+}
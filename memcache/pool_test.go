@@ -0,0 +1,276 @@
+package memcache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxOpenConns(t *testing.T) {
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		time.Sleep(20 * time.Millisecond)
+		endHandler(line, r, w)
+	})
+	c := New(s.Addr())
+	c.Timeout = time.Second
+	c.MaxOpenConns = 2
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get("foo", 1); err != nil {
+				t.Errorf("Get: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.Conns(); n > 2 {
+		t.Errorf("server accepted %d connections, want at most 2", n)
+	}
+}
+
+func TestPoolExhausted(t *testing.T) {
+	release := make(chan struct{})
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		<-release
+		endHandler(line, r, w)
+	})
+	c := New(s.Addr())
+	c.Timeout = 50 * time.Millisecond
+	c.MaxOpenConns = 1
+
+	addr, err := c.selector.PickServer("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err := c.getConn(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get("foo", 1)
+	if _, ok := err.(*PoolExhaustedError); !ok {
+		t.Fatalf("Get with pool in use: got %v, want *PoolExhaustedError", err)
+	}
+
+	// Closing the held connection frees its slot for the next request.
+	close(release)
+	cn.close()
+	if _, err := c.Get("foo", 1); err != nil {
+		t.Fatalf("Get after close: %v", err)
+	}
+}
+
+func TestConnWaitersFIFO(t *testing.T) {
+	s := newStubServer(t, endHandler)
+	c := New(s.Addr())
+	c.Timeout = time.Second
+	c.MaxOpenConns = 1
+
+	addr, err := c.selector.PickServer("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, err := c.getConn(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 5
+	order := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			cn, err := c.getConn(addr)
+			if err != nil {
+				t.Errorf("waiter %d: %v", i, err)
+				order <- -1
+				return
+			}
+			order <- i
+			cn.release()
+		}(i)
+		// Let waiter i queue up before the next one.
+		for {
+			p := c.pool(addr)
+			p.mu.Lock()
+			queued := len(p.reqs)
+			p.mu.Unlock()
+			if queued == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	held.release()
+	for i := 0; i < n; i++ {
+		if got := <-order; got != i {
+			t.Fatalf("waiter %d served in position %d", got, i)
+		}
+	}
+}
+
+// baselineClient holds the free connections as the client did before
+// per-address pools, under a single Client.lk keyed by addr.String().
+// Its methods are copied verbatim from that client, to compare against
+// in benchmarks.
+type baselineClient struct {
+	*Client
+	lk       sync.Mutex
+	freeconn map[string][]*conn
+}
+
+func (c *baselineClient) putFreeConn(addr net.Addr, cn *conn) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.freeconn == nil {
+		c.freeconn = make(map[string][]*conn)
+	}
+	freelist := c.freeconn[addr.String()]
+	if len(freelist) >= c.maxIdleConns() {
+		cn.nc.Close()
+		return
+	}
+	c.freeconn[addr.String()] = append(freelist, cn)
+}
+
+func (c *baselineClient) getFreeConn(addr net.Addr) (cn *conn, ok bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.freeconn == nil {
+		return nil, false
+	}
+	freelist, ok := c.freeconn[addr.String()]
+	if !ok || len(freelist) == 0 {
+		return nil, false
+	}
+	cn = freelist[len(freelist)-1]
+	c.freeconn[addr.String()] = freelist[:len(freelist)-1]
+	return cn, true
+}
+
+const benchPoolConns = 256
+
+// benchPoolAddrs returns the addresses of a ServerList with n servers,
+// as PickServer hands them out.
+func benchPoolAddrs(b *testing.B, n int) []net.Addr {
+	var ss ServerList
+	servers := make([]string, n)
+	for i := range servers {
+		servers[i] = fmt.Sprintf("127.0.0.1:%d", 10000+i)
+	}
+	if err := ss.SetServers(servers...); err != nil {
+		b.Fatal(err)
+	}
+	var addrs []net.Addr
+	ss.Each(func(a net.Addr) error {
+		addrs = append(addrs, a)
+		return nil
+	})
+	return addrs
+}
+
+// BenchmarkPoolGetPut compares taking and putting back idle
+// connections with per-address pools against the baseline single-lock
+// pool. Requests to one server still serialize on its pool's lock: with
+// a single server, per-address pools only save the addr.String() calls
+// and the map lookups, and scale no better with goroutines. They scale
+// with the number of servers.
+func BenchmarkPoolGetPut(b *testing.B) {
+	for _, n := range []int{1, 8} {
+		b.Run(fmt.Sprintf("pool=addr/servers=%d", n), func(b *testing.B) {
+			c := &Client{MaxIdleConns: benchPoolConns}
+			addrs := benchPoolAddrs(b, n)
+			for _, addr := range addrs {
+				p := c.pool(addr)
+				for i := 0; i < benchPoolConns; i++ {
+					p.put(&conn{c: c, addr: addr, pool: p, createdAt: time.Now()})
+				}
+			}
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					p := c.pool(addrs[i%len(addrs)])
+					if cn, ok := p.get(); ok {
+						p.put(cn)
+					}
+					i++
+				}
+			})
+		})
+		b.Run(fmt.Sprintf("pool=baseline/servers=%d", n), func(b *testing.B) {
+			c := &baselineClient{Client: &Client{MaxIdleConns: benchPoolConns}}
+			addrs := benchPoolAddrs(b, n)
+			for _, addr := range addrs {
+				for i := 0; i < benchPoolConns; i++ {
+					c.putFreeConn(addr, &conn{addr: addr})
+				}
+			}
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					addr := addrs[i%len(addrs)]
+					if cn, ok := c.getFreeConn(addr); ok {
+						c.putFreeConn(addr, cn)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestPoolsPrunedOnServerChange(t *testing.T) {
+	a, b := newStubServer(t, endHandler), newStubServer(t, endHandler)
+	ss := new(ServerList)
+	if err := ss.SetServers(a.Addr(), b.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFromSelector(ss)
+	c.Timeout = time.Second
+	if err := c.Warmup(2); err != nil {
+		t.Fatalf("Warmup: %v", err)
+	}
+	addrs, _ := c.servers()
+	pb := c.pool(addrs[1])
+	inUse, err := c.getConn(addrs[1])
+	if err != nil {
+		t.Fatalf("getConn: %v", err)
+	}
+
+	if err := ss.SetServers(a.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get("foo", 1); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	c.lk.Lock()
+	_, kept := c.poolsByName[a.Addr()]
+	_, dropped := c.poolsByName[b.Addr()]
+	c.lk.Unlock()
+	if !kept || dropped {
+		t.Errorf("after removing %s, pools = %v", b.Addr(), c.poolsByName)
+	}
+	n := 0
+	c.pools.Range(func(k, _ interface{}) bool {
+		n++
+		return true
+	})
+	if n != 1 {
+		t.Errorf("%d addresses map to pools, want 1", n)
+	}
+
+	inUse.release()
+	pb.mu.Lock()
+	free, open := len(pb.free), pb.numOpen
+	pb.mu.Unlock()
+	if free != 0 || open != 0 {
+		t.Errorf("removed pool has %d idle and %d open connections, want none", free, open)
+	}
+}
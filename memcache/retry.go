@@ -0,0 +1,128 @@
+package memcache
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultRetryAttempts is the default number of attempts, including
+	// the first one, made by a RetryPolicy.
+	DefaultRetryAttempts = 3
+
+	// DefaultRetryBackoff is the default delay before the first retry.
+	DefaultRetryBackoff = time.Millisecond
+
+	// DefaultMaxRetryBackoff is the default upper bound of the delay
+	// between two attempts.
+	DefaultMaxRetryBackoff = 100 * time.Millisecond
+)
+
+// defaultRetryOps are the operations a RetryPolicy retries if its Ops
+// field is nil. They are safe to repeat if a request fails after the
+// server has already processed it.
+var defaultRetryOps = []string{"get", "ret", "gat", "touch", "delete"}
+
+// RetryPolicy configures the retrying of requests that failed with a
+// network error. Only idempotent operations are retried by default;
+// incr, decr and add, whose repetition can change the outcome, must be
+// opted into through Ops.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts per request,
+	// including the first one. If zero, DefaultRetryAttempts is used.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. It doubles with
+	// every further retry, up to MaxBackoff, and is jittered by up to
+	// half its value. If zero, DefaultRetryBackoff is used.
+	Backoff time.Duration
+
+	// MaxBackoff bounds the delay between attempts. If zero,
+	// DefaultMaxRetryBackoff is used.
+	MaxBackoff time.Duration
+
+	// Ops lists the operations that are retried, by command name:
+	// "get", "ret", "gat", "touch", "delete", "set", "add", "replace",
+	// "cas", "append", "prepend", "incr" and "decr". If nil, get, ret,
+	// gat, touch and delete are retried.
+	Ops []string
+
+	// OnRetry, if non-nil, is called before every retry with the
+	// operation, the number of the attempt that failed and its error.
+	OnRetry func(op string, attempt int, err error)
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return DefaultRetryAttempts
+}
+
+func (p *RetryPolicy) retries(op string) bool {
+	ops := p.Ops
+	if ops == nil {
+		ops = defaultRetryOps
+	}
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay after the given failed attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d, max := p.Backoff, p.MaxBackoff
+	if d <= 0 {
+		d = DefaultRetryBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxRetryBackoff
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryable reports whether err is a network failure after which the
+// request may be sent again.
+func retryable(err error) bool {
+	var ne net.Error
+	var cte *ConnectTimeoutError
+	switch {
+	case errors.As(err, &ne), errors.As(err, &cte):
+		return true
+	case err == io.EOF, err == io.ErrUnexpectedEOF:
+		return true
+	}
+	return false
+}
+
+// retry runs fn, the request for operation op, and runs it again
+// according to the client's RetryPolicy if it fails with a network
+// error.
+func (c *Client) retry(op string, fn func() error) error {
+	p := c.Retry
+	if p == nil || !p.retries(op) {
+		return fn()
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || attempt >= p.maxAttempts() {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(op, attempt, err)
+		}
+		time.Sleep(p.backoff(attempt))
+	}
+}
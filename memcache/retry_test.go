@@ -0,0 +1,108 @@
+package memcache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// flakyClient returns a client whose first connection fails on its
+// first write, and whose later connections work.
+func flakyClient() *Client {
+	var dials int
+	pipe := pipeDialer(&dials)
+	c := New("127.0.0.1:1")
+	c.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		nc, err := pipe(ctx, network, addr)
+		if dials == 1 {
+			return &faultyConn{Conn: nc}, err
+		}
+		return nc, err
+	}
+	return c
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	c := flakyClient()
+	var retries []string
+	c.Retry = &RetryPolicy{
+		Backoff: time.Microsecond,
+		OnRetry: func(op string, attempt int, err error) {
+			if !errors.Is(err, errInjected) {
+				t.Errorf("OnRetry(%q, %d): unexpected error %v", op, attempt, err)
+			}
+			retries = append(retries, op)
+		},
+	}
+	if _, err := c.Get("foo", 1); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(retries) != 1 || retries[0] != "get" {
+		t.Errorf("retries = %q, want [get]", retries)
+	}
+}
+
+func TestRetrySkipsNonIdempotent(t *testing.T) {
+	c := flakyClient()
+	retried := false
+	c.Retry = &RetryPolicy{
+		Backoff: time.Microsecond,
+		OnRetry: func(string, int, error) { retried = true },
+	}
+	if _, err := c.Increment("num", 1); !errors.Is(err, errInjected) {
+		t.Fatalf("Increment: got %v, want injected fault", err)
+	}
+	if retried {
+		t.Error("Increment was retried without being opted in")
+	}
+
+	c = flakyClient()
+	c.Retry = &RetryPolicy{
+		Backoff: time.Microsecond,
+		Ops:     []string{"incr"},
+		OnRetry: func(string, int, error) { retried = true },
+	}
+	c.Increment("num", 1)
+	if !retried {
+		t.Error("Increment was not retried after opting in")
+	}
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	c := New("127.0.0.1:1")
+	attempts := 0
+	c.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		attempts++
+		return nil, &net.OpError{Op: "dial", Net: network, Err: errInjected}
+	}
+	c.Retry = &RetryPolicy{MaxAttempts: 4, Backoff: time.Microsecond}
+	if _, err := c.Get("foo", 1); !errors.Is(err, errInjected) {
+		t.Fatalf("Get: got %v, want injected fault", err)
+	}
+	if attempts != 4 {
+		t.Errorf("made %d attempts, want 4", attempts)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, 50 * time.Millisecond},
+		{10, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(tt.attempt); d < tt.max/2 || d > tt.max {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v]", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
@@ -35,6 +35,28 @@ type ServerSelector interface {
 	Each(func(net.Addr) error) error
 }
 
+// ReplicaSelector is implemented by ServerSelectors whose servers
+// replicate each other's writes, so that more than one of them holds a
+// key. Hedged reads use it to find the replica a delayed request is
+// sent to. The client stores items on PickServer(key) only: a selector
+// must not implement ReplicaSelector unless the servers it names
+// receive the writes from there. None of the selectors of this package
+// do.
+type ReplicaSelector interface {
+	// PickReplicas returns up to n distinct servers holding key, in
+	// order of preference. The first is the server a read of key would
+	// go to: the one PickReadServer, or else PickServer, would return.
+	PickReplicas(key string, n int) ([]net.Addr, error)
+}
+
+// ReadSelector is implemented by ServerSelectors that may send reads of
+// a key to another server than its writes, e.g. to a replica when the
+// key's server is unhealthy. PickServer still places the writes.
+type ReadSelector interface {
+	// PickReadServer returns the server a read of key is sent to.
+	PickReadServer(key string) (net.Addr, error)
+}
+
 // ServerList is a simple ServerSelector. Its zero value is usable.
 type ServerList struct {
 	mu    sync.RWMutex
@@ -56,6 +78,23 @@ func newStaticAddr(a net.Addr) net.Addr {
 func (s *staticAddr) Network() string { return s.ntw }
 func (s *staticAddr) String() string  { return s.str }
 
+// resolveServer resolves a server name to a TCP address, or to a unix
+// socket address if it contains a slash.
+func resolveServer(server string) (net.Addr, error) {
+	if strings.Contains(server, "/") {
+		addr, err := net.ResolveUnixAddr("unix", server)
+		if err != nil {
+			return nil, err
+		}
+		return newStaticAddr(addr), nil
+	}
+	tcpaddr, err := net.ResolveTCPAddr("tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	return newStaticAddr(tcpaddr), nil
+}
+
 // SetServers changes a ServerList's set of servers at runtime and is
 // safe for concurrent use by multiple goroutines.
 //
@@ -68,19 +107,11 @@ func (s *staticAddr) String() string  { return s.str }
 func (ss *ServerList) SetServers(servers ...string) error {
 	naddr := make([]net.Addr, len(servers))
 	for i, server := range servers {
-		if strings.Contains(server, "/") {
-			addr, err := net.ResolveUnixAddr("unix", server)
-			if err != nil {
-				return err
-			}
-			naddr[i] = newStaticAddr(addr)
-		} else {
-			tcpaddr, err := net.ResolveTCPAddr("tcp", server)
-			if err != nil {
-				return err
-			}
-			naddr[i] = newStaticAddr(tcpaddr)
+		addr, err := resolveServer(server)
+		if err != nil {
+			return err
 		}
+		naddr[i] = addr
 	}
 
 	ss.mu.Lock()
@@ -120,10 +151,15 @@ func (ss *ServerList) PickServer(key string) (net.Addr, error) {
 	if len(ss.addrs) == 1 {
 		return ss.addrs[0], nil
 	}
+	cs := keyHash(key)
+	return ss.addrs[cs%uint32(len(ss.addrs))], nil
+}
+
+// keyHash returns the CRC-32 checksum of key that servers are picked by.
+func keyHash(key string) uint32 {
 	bufp := keyBufPool.Get().(*[]byte)
 	n := copy(*bufp, key)
 	cs := crc32.ChecksumIEEE((*bufp)[:n])
 	keyBufPool.Put(bufp)
-
-	return ss.addrs[cs%uint32(len(ss.addrs))], nil
+	return cs
 }
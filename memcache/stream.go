@@ -0,0 +1,181 @@
+package memcache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultChunkSize is the default largest value SetStream stores
+	// under a single key. It leaves room below memcached's default
+	// item size limit of 1 MB for the item's header.
+	DefaultChunkSize = 1000 * 1000
+
+	// manifestFlags are the flags of a manifest item, which tells
+	// GetStream where to find the chunks of a value.
+	manifestFlags = 0x6d636368 // "mcch"
+)
+
+// ErrCorruptStream is returned by GetStream when a value's manifest
+// can't be parsed or its chunks don't add up to the value's size.
+var ErrCorruptStream = errors.New("memcache: corrupt streamed value")
+
+// streamSeq tells apart the values stored by SetStream in the same
+// nanosecond.
+var streamSeq atomic.Uint64
+
+// chunkSize returns the largest chunk SetStream stores under chunk
+// keys of at most keyLen bytes. With a Layout, chunks fill whole blocks
+// together with the header.
+func (c *Client) chunkSize(keyLen int) int {
+	n := c.ChunkSize
+	if n <= 0 {
+		n = DefaultChunkSize
+	}
+	if c.UseZsolt {
+		// "set <key> 0 0 <bytes>\r\n<data>\r\n" must fit in a frame.
+		max := maxZsoltPayload - len("set  0 0 0000\r\n\r\n") - keyLen
+		if n > max {
+			n = max
+		}
+	}
+	if c.Layout != nil {
+		n = c.Layout.alignDown(n)
+	}
+	return n
+}
+
+// chunkKey returns the key of the i-th chunk of the value stored under
+// key with the manifest id.
+func chunkKey(key, id string, i int) string {
+	return key + ":" + id + ":" + strconv.Itoa(i)
+}
+
+// SetStream stores the size bytes read from r under key, unconditionally.
+// A value larger than the client's ChunkSize is split into chunks, each
+// stored under a key of its own, and a manifest listing them is stored
+// under key once all of them are; the value can only be read back with
+// GetStream. Smaller values are stored under key as Set would.
+//
+// With a Layout, the chunks fill whole blocks; unless the Layout pads
+// values, the last chunk and the manifest must too. SetStream returns a
+// *LayoutError before storing anything if they can't, or if the Layout
+// requires a JSON prefix.
+//
+// The chunks of a value that is overwritten are left to expire or be
+// evicted.
+func (c *Client) SetStream(key string, r io.Reader, size int64) error {
+	if !legalKey(c.serverKey(key)) {
+		return ErrMalformedKey
+	}
+	if size < 0 {
+		return fmt.Errorf("memcache: negative stream size %d", size)
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.FormatUint(streamSeq.Add(1), 36)
+	chunk := int64(c.chunkSize(len(c.serverKey(chunkKey(key, id, math.MaxInt)))))
+	if size <= chunk {
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		return c.Set(&Item{Key: key, Value: buf})
+	}
+
+	n := int((size + chunk - 1) / chunk)
+	manifest := fmt.Sprintf("%s %d %d", id, n, size)
+	if err := c.fitStream(key, id, n, size, chunk, len(manifest)); err != nil {
+		return err
+	}
+	buf := make([]byte, chunk)
+	for i := 0; i < n; i++ {
+		part := buf[:min(chunk, size-int64(i)*chunk)]
+		if _, err := io.ReadFull(r, part); err != nil {
+			return err
+		}
+		ck := chunkKey(key, id, i)
+		if !legalKey(c.serverKey(ck)) {
+			return ErrMalformedKey
+		}
+		if err := c.Set(&Item{Key: ck, Value: part}); err != nil {
+			return err
+		}
+	}
+	return c.Set(&Item{Key: key, Value: []byte(manifest), Flags: manifestFlags})
+}
+
+// fitStream checks the n chunks of chunk bytes a value of size bytes is
+// split into, and its manifest of manifestLen bytes, against the
+// client's Layout, so that SetStream fails before it stores any of them.
+func (c *Client) fitStream(key, id string, n int, size, chunk int64, manifestLen int) error {
+	l := c.Layout
+	if l == nil {
+		return nil
+	}
+	if l.JSONPrefix {
+		return &LayoutError{Key: key, Reason: "streamed values have no JSON prefix"}
+	}
+	for i := 0; i < n; i++ {
+		if _, err := l.pad("set", c.serverKey(chunkKey(key, id, i)), int(min(chunk, size-int64(i)*chunk))); err != nil {
+			return err
+		}
+	}
+	_, err := l.pad("set", c.serverKey(key), manifestLen)
+	return err
+}
+
+// GetStream writes the value stored under key by SetStream, or by any of
+// the storage commands, to w, and returns the number of bytes written.
+// ErrCacheMiss is returned if the value, or any of its chunks, is
+// missing; in the latter case part of the value may have been written
+// to w already.
+func (c *Client) GetStream(key string, w io.Writer) (int64, error) {
+	it, err := c.GetInto(key, nil)
+	if err != nil {
+		return 0, err
+	}
+	if it.Flags != manifestFlags {
+		n, err := w.Write(it.Value)
+		return int64(n), err
+	}
+	// With a Layout, the manifest and the last chunk may have been padded
+	// with zeros.
+	f := strings.Fields(string(bytes.TrimRight(it.Value, "\x00")))
+	if len(f) != 3 {
+		return 0, ErrCorruptStream
+	}
+	n, err1 := strconv.Atoi(f[1])
+	size, err2 := strconv.ParseInt(f[2], 10, 64)
+	if err1 != nil || err2 != nil || n < 0 || size < 0 {
+		return 0, ErrCorruptStream
+	}
+
+	var written int64
+	var buf []byte
+	for i := 0; i < n; i++ {
+		ck, err := c.GetInto(chunkKey(key, f[0], i), buf)
+		if err != nil {
+			return written, err
+		}
+		buf = ck.Value
+		value := ck.Value
+		if rest := size - written; i == n-1 && int64(len(value)) > rest {
+			value = value[:max(rest, 0)]
+		}
+		m, err := w.Write(value)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+	}
+	if written != size {
+		return written, ErrCorruptStream
+	}
+	return written, nil
+}
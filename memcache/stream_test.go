@@ -0,0 +1,131 @@
+package memcache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		c.ChunkSize = 100
+		value := make([]byte, 1050)
+		rand.New(rand.NewSource(1)).Read(value)
+		if err := c.SetStream("big", bytes.NewReader(value), int64(len(value))); err != nil {
+			t.Fatalf("SetStream: %v", err)
+		}
+		if it, _ := fm.item("big"); it.flags != manifestFlags {
+			t.Errorf("manifest flags = %#x, want %#x", it.flags, manifestFlags)
+		}
+		var buf bytes.Buffer
+		if n, err := c.GetStream("big", &buf); err != nil || n != int64(len(value)) {
+			t.Fatalf("GetStream = %d, %v; want %d", n, err, len(value))
+		}
+		if !bytes.Equal(buf.Bytes(), value) {
+			t.Errorf("GetStream returned a different value")
+		}
+
+		// Small values are stored as is.
+		if err := c.SetStream("small", strings.NewReader("hello"), 5); err != nil {
+			t.Fatalf("SetStream: %v", err)
+		}
+		if it, _ := fm.item("small"); string(it.value) != "hello" {
+			t.Errorf("small value = %q, want hello", it.value)
+		}
+		if err := c.Set(&Item{Key: "plain", Value: []byte("world")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		buf.Reset()
+		if _, err := c.GetStream("plain", &buf); err != nil || buf.String() != "world" {
+			t.Errorf("GetStream(plain) = %q, %v", buf.String(), err)
+		}
+
+		if _, err := c.GetStream("missing", io.Discard); err != ErrCacheMiss {
+			t.Errorf("GetStream of missing key: got %v, want ErrCacheMiss", err)
+		}
+		if err := c.SetStream("short", strings.NewReader("abc"), 500); err != io.ErrUnexpectedEOF {
+			t.Errorf("SetStream of short reader: got %v, want io.ErrUnexpectedEOF", err)
+		}
+	})
+}
+
+func TestStreamEvictedChunk(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		c.ChunkSize = 10
+		if err := c.SetStream("k", strings.NewReader(strings.Repeat("x", 35)), 35); err != nil {
+			t.Fatalf("SetStream: %v", err)
+		}
+		fm.mu.Lock()
+		n := 0
+		for key := range fm.items {
+			if strings.HasPrefix(key, "k:") && strings.HasSuffix(key, ":2") {
+				delete(fm.items, key)
+				n++
+			}
+		}
+		fm.mu.Unlock()
+		if n != 1 {
+			t.Fatalf("found %d third chunks, want 1", n)
+		}
+		if _, err := c.GetStream("k", io.Discard); err != ErrCacheMiss {
+			t.Errorf("GetStream with evicted chunk: got %v, want ErrCacheMiss", err)
+		}
+	})
+}
+
+func TestStreamZsoltFrameLimit(t *testing.T) {
+	fm := newFakeMemcache()
+	c := New("127.0.0.1:1")
+	c.UseZsolt = true
+	c.Dialer = zsoltDialer(fm.zsoltHandler)
+	value := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := c.SetStream("k", bytes.NewReader(value), int64(len(value))); err != nil {
+		t.Fatalf("SetStream: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := c.GetStream("k", &buf); err != nil || !bytes.Equal(buf.Bytes(), value) {
+		t.Errorf("GetStream = %d bytes, %v", buf.Len(), err)
+	}
+}
+
+func TestStreamLayout(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		c.ChunkSize = 100
+		c.Layout = &FPGALayout{KeyLen: -1, Pad: true}
+		value := make([]byte, 1050)
+		rand.New(rand.NewSource(1)).Read(value)
+		if err := c.SetStream("big", bytes.NewReader(value), int64(len(value))); err != nil {
+			t.Fatalf("SetStream: %v", err)
+		}
+		fm.mu.Lock()
+		for key, it := range fm.items {
+			if n := len(it.value) + DefaultFPGAHeaderLen; n%DefaultFPGABlockSize != 0 {
+				t.Errorf("%s: value of %d bytes doesn't fill whole blocks", key, len(it.value))
+			}
+		}
+		fm.mu.Unlock()
+		var buf bytes.Buffer
+		if n, err := c.GetStream("big", &buf); err != nil || !bytes.Equal(buf.Bytes(), value) {
+			t.Errorf("GetStream = %d, %v", n, err)
+		}
+
+		// Layouts the chunks or the manifest can't meet are rejected before
+		// anything is stored.
+		for _, l := range []*FPGALayout{{KeyLen: -1}, {Pad: true}, {KeyLen: -1, Pad: true, JSONPrefix: true}} {
+			c.Layout = l
+			if err := c.SetStream("bad", bytes.NewReader(value), int64(len(value))); !errors.Is(err, ErrLayout) {
+				t.Errorf("SetStream with %+v: got %v, want ErrLayout", *l, err)
+			}
+		}
+		fm.mu.Lock()
+		for key := range fm.items {
+			if strings.HasPrefix(key, "bad") {
+				t.Errorf("SetStream rejected by the layout stored %s", key)
+			}
+		}
+		fm.mu.Unlock()
+	})
+}
@@ -0,0 +1,118 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubServer is a minimal stand-in for a memcached server speaking the
+// plain text protocol. Every request line is passed to the handler
+// together with the connection's reader, for commands that carry data,
+// and writer. The response is flushed after the handler returns.
+type stubServer struct {
+	t      *testing.T
+	ln     net.Listener
+	handle func(line string, r *bufio.Reader, w *bufio.Writer)
+
+	mu    sync.Mutex
+	conns int // number of accepted connections
+}
+
+func newStubServer(t *testing.T, handle func(line string, r *bufio.Reader, w *bufio.Writer)) *stubServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("skipping test; can't listen on loopback: %v", err)
+	}
+	s := &stubServer{t: t, ln: ln, handle: handle}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *stubServer) Addr() string { return s.ln.Addr().String() }
+
+// Conns returns the number of connections accepted so far.
+func (s *stubServer) Conns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func (s *stubServer) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.serveConn(nc)
+	}
+}
+
+func (s *stubServer) serveConn(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		s.handle(strings.TrimRight(line, "\r\n"), r, w)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// endHandler answers every request line with END, which is what Get
+// and Ret expect from the plain protocol.
+func endHandler(line string, r *bufio.Reader, w *bufio.Writer) {
+	if strings.HasPrefix(line, "ret ") {
+		// Skip the request's data block.
+		r.ReadString('\n')
+	}
+	w.WriteString("END\r\n")
+}
+
+// zsoltDialer returns a Dialer that connects to an in-memory server
+// speaking the Zsolt protocol. Each request frame's payload, without
+// padding, is passed to handle; a non-nil reply is sent back after the
+// 8 dummy bytes that precede every response.
+func zsoltDialer(handle func(payload []byte) []byte) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			header := make([]byte, 16)
+			for {
+				if _, err := io.ReadFull(server, header); err != nil {
+					return
+				}
+				if header[0] != 0xFF || header[1] != 0xFF {
+					return
+				}
+				payload := make([]byte, int(header[4])*8)
+				if _, err := io.ReadFull(server, payload); err != nil {
+					return
+				}
+				reply := handle(bytes.TrimRight(payload, "\x00"))
+				if reply == nil {
+					continue
+				}
+				if _, err := server.Write(append(make([]byte, 8), reply...)); err != nil {
+					return
+				}
+			}
+		}()
+		return client, nil
+	}
+}
@@ -0,0 +1,59 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestScanReadDeadlinePerResponse(t *testing.T) {
+	// Each of the scan's responses takes 30ms, well within ReadTimeout,
+	// but together they take far longer.
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		for i := 0; i < 5; i++ {
+			time.Sleep(30 * time.Millisecond)
+			w.WriteString("END\r\n")
+			w.Flush()
+		}
+	})
+	c := New(s.Addr())
+	c.Timeout = 10 * time.Millisecond
+	c.ReadTimeout = 100 * time.Millisecond
+	if _, err := c.Get("foo", 5); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	s := newStubServer(t, func(line string, r *bufio.Reader, w *bufio.Writer) {
+		time.Sleep(100 * time.Millisecond)
+		endHandler(line, r, w)
+	})
+	c := New(s.Addr())
+	c.Timeout = time.Second
+	c.ReadTimeout = 20 * time.Millisecond
+	_, err := c.Get("foo", 1)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	c := New("127.0.0.1:1")
+	c.Timeout = 5 * time.Second
+	c.DialTimeout = 20 * time.Millisecond
+	c.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	start := time.Now()
+	_, err := c.Get("foo", 1)
+	if _, ok := err.(*ConnectTimeoutError); !ok {
+		t.Fatalf("got %v, want *ConnectTimeoutError", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("dial took %v, want about DialTimeout", d)
+	}
+}
@@ -0,0 +1,106 @@
+package memcache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGetInto(t *testing.T) {
+	forEachProtocol(t, func(t *testing.T, c *Client, fm *fakeMemcache) {
+		buf := make([]byte, 0, 64)
+		if _, err := c.GetInto("k", buf); err != ErrCacheMiss {
+			t.Errorf("GetInto of missing key: got %v, want ErrCacheMiss", err)
+		}
+		value := bytes.Repeat([]byte("v"), 64)
+		if err := c.Set(&Item{Key: "k", Value: value, Flags: 5}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		it, err := c.GetInto("k", buf)
+		if err != nil {
+			t.Fatalf("GetInto: %v", err)
+		}
+		if it.Key != "k" || it.Flags != 5 || !bytes.Equal(it.Value, value) {
+			t.Errorf("GetInto = %+v", it)
+		}
+		if &it.Value[0] != &buf[:1][0] {
+			t.Errorf("value wasn't read into buf")
+		}
+
+		// A buffer too small is replaced.
+		small := make([]byte, 8)
+		if it, err = c.GetInto("k", small); err != nil || !bytes.Equal(it.Value, value) {
+			t.Errorf("GetInto with small buffer = %+v, %v", it, err)
+		}
+		if it, err = c.GetInto("k", nil); err != nil || !bytes.Equal(it.Value, value) {
+			t.Errorf("GetInto with nil buffer = %+v, %v", it, err)
+		}
+
+		// The connection is still in sync.
+		if err := c.Set(&Item{Key: "j", Value: []byte("x")}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if it, err = c.GetInto("j", buf); err != nil || string(it.Value) != "x" {
+			t.Errorf("GetInto(j) = %+v, %v", it, err)
+		}
+	})
+}
+
+func TestGetIntoZsoltMissMarker(t *testing.T) {
+	c := New("127.0.0.1:1")
+	c.UseZsolt = true
+	c.Dialer = zsoltDialer(func(payload []byte) []byte {
+		return zsoltMiss[:]
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetInto("k", nil); err != ErrCacheMiss {
+			t.Fatalf("GetInto #%d: got %v, want ErrCacheMiss", i, err)
+		}
+	}
+}
+
+func TestParseValueLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		key   string
+		flags uint32
+		size  int
+		casid uint64
+		ok    bool
+	}{
+		{"VALUE foo 3 64\r\n", "foo", 3, 64, 0, true},
+		{"VALUE foo 0 5 99\r\n", "foo", 0, 5, 99, true},
+		{"VALUE foo 0\r\n", "", 0, 0, 0, false},
+		{"VALUE foo x 5\r\n", "", 0, 0, 0, false},
+		{"VALUE foo 0 -1\r\n", "", 0, 0, 0, false},
+		{"VALUE foo 0 5 1 2\r\n", "", 0, 0, 0, false},
+		{"VALUES foo 0 5\r\n", "", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		key, flags, size, casid, ok := parseValueLine([]byte(tt.line))
+		if ok != tt.ok || string(key) != tt.key || flags != tt.flags || size != tt.size || casid != tt.casid {
+			t.Errorf("parseValueLine(%q) = %q, %d, %d, %d, %v", tt.line, key, flags, size, casid, ok)
+		}
+	}
+}
+
+func BenchmarkGetInto(b *testing.B) {
+	const key = "0123456789abcdef"
+	reply := "VALUE " + key + " 0 64\r\n" + string(make([]byte, 64)) + "\r\nEND\r\n"
+	for _, zsolt := range []bool{false, true} {
+		name := "plain"
+		if zsolt {
+			name = "zsolt"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := benchClient(b, zsolt, 1, reply)
+			buf := make([]byte, 64)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.GetInto(key, buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
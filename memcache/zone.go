@@ -0,0 +1,199 @@
+package memcache
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultFailureCooldown is the default time a ZoneSelector avoids a
+// server after a failed request.
+const DefaultFailureCooldown = time.Second
+
+// HealthObserver is implemented by selectors that want to learn the
+// outcome of every request the Client sends to one of their servers.
+// err is nil or a resumable cache error if the server answered.
+type HealthObserver interface {
+	ObserveResult(addr net.Addr, err error)
+}
+
+// ZoneReporter is implemented by selectors that know the zone of their
+// servers. The Client uses it to count the requests served per zone.
+type ZoneReporter interface {
+	ZoneOf(addr net.Addr) string
+}
+
+// ZoneSelector is a ServerSelector for servers spread over several zones,
+// such as racks. A key is hashed onto one server within every zone.
+//
+// Writes always go to the key's server in LocalZone (or, if LocalZone
+// has no servers, in the first zone in name order). Reads prefer that
+// server too, but if it recently failed they fall back to the key's
+// server in the next zone, in zone name order. The selector doesn't
+// replicate writes across zones: reads that fall back only find the
+// data the other zones were given by other means, such as clients
+// local to them. Its zero value is usable.
+type ZoneSelector struct {
+	// LocalZone is the zone the client runs in.
+	LocalZone string
+
+	// FailureCooldown is how long a server is avoided after a failed
+	// request. If zero, DefaultFailureCooldown is used.
+	FailureCooldown time.Duration
+
+	mu     sync.RWMutex
+	names  []string // sorted zone names
+	zones  map[string][]net.Addr
+	zoneOf map[string]string // addr.String() -> zone
+
+	hmu       sync.Mutex
+	downUntil map[string]time.Time // addr.String() -> end of cooldown
+}
+
+// SetZones replaces the selector's servers, given as a map from zone
+// name to the servers in that zone. Like ServerList.SetServers, it
+// resolves all names first and makes no changes if any of them fails.
+func (zs *ZoneSelector) SetZones(zones map[string][]string) error {
+	nzones := make(map[string][]net.Addr, len(zones))
+	zoneOf := make(map[string]string)
+	names := make([]string, 0, len(zones))
+	for zone, servers := range zones {
+		if len(servers) == 0 {
+			continue
+		}
+		addrs := make([]net.Addr, len(servers))
+		for i, server := range servers {
+			addr, err := resolveServer(server)
+			if err != nil {
+				return err
+			}
+			addrs[i] = addr
+			zoneOf[addr.String()] = zone
+		}
+		nzones[zone] = addrs
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+
+	zs.mu.Lock()
+	defer zs.mu.Unlock()
+	zs.names = names
+	zs.zones = nzones
+	zs.zoneOf = zoneOf
+	return nil
+}
+
+// ZoneOf returns the zone of addr, or "" if it isn't one of the
+// selector's servers.
+func (zs *ZoneSelector) ZoneOf(addr net.Addr) string {
+	zs.mu.RLock()
+	defer zs.mu.RUnlock()
+	return zs.zoneOf[addr.String()]
+}
+
+// ObserveResult marks addr as failed for FailureCooldown if err is a
+// network or server failure, and as healthy otherwise.
+func (zs *ZoneSelector) ObserveResult(addr net.Addr, err error) {
+	zs.hmu.Lock()
+	defer zs.hmu.Unlock()
+	if answeredError(err) {
+		delete(zs.downUntil, addr.String())
+		return
+	}
+	if zs.downUntil == nil {
+		zs.downUntil = make(map[string]time.Time)
+	}
+	cooldown := zs.FailureCooldown
+	if cooldown <= 0 {
+		cooldown = DefaultFailureCooldown
+	}
+	zs.downUntil[addr.String()] = time.Now().Add(cooldown)
+}
+
+func (zs *ZoneSelector) healthy(addr net.Addr, now time.Time) bool {
+	zs.hmu.Lock()
+	defer zs.hmu.Unlock()
+	until, ok := zs.downUntil[addr.String()]
+	return !ok || now.After(until)
+}
+
+// candidates returns the key's server in every zone, local zone first.
+// zs.mu must be held.
+func (zs *ZoneSelector) candidates(key string) []net.Addr {
+	cs := keyHash(key)
+	addrs := make([]net.Addr, 0, len(zs.names))
+	if local := zs.zones[zs.LocalZone]; len(local) > 0 {
+		addrs = append(addrs, local[cs%uint32(len(local))])
+	}
+	for _, zone := range zs.names {
+		if zone == zs.LocalZone {
+			continue
+		}
+		zaddrs := zs.zones[zone]
+		addrs = append(addrs, zaddrs[cs%uint32(len(zaddrs))])
+	}
+	return addrs
+}
+
+// PickServer returns the key's server in the local zone, regardless of
+// its health, so that writes stay in the zone reads prefer.
+func (zs *ZoneSelector) PickServer(key string) (net.Addr, error) {
+	zs.mu.RLock()
+	defer zs.mu.RUnlock()
+	cand := zs.candidates(key)
+	if len(cand) == 0 {
+		return nil, ErrNoServers
+	}
+	return cand[0], nil
+}
+
+// PickReadServer returns the key's server in the nearest zone whose
+// server is healthy. If none is, the local zone's server is returned.
+func (zs *ZoneSelector) PickReadServer(key string) (net.Addr, error) {
+	addrs, err := zs.readOrder(key, 1)
+	if err != nil {
+		return nil, err
+	}
+	return addrs[0], nil
+}
+
+// readOrder returns up to n of the key's servers, one per zone, with
+// healthy servers ahead of failed ones and the local zone first.
+func (zs *ZoneSelector) readOrder(key string, n int) ([]net.Addr, error) {
+	zs.mu.RLock()
+	defer zs.mu.RUnlock()
+	cand := zs.candidates(key)
+	if len(cand) == 0 {
+		return nil, ErrNoServers
+	}
+	now := time.Now()
+	addrs := make([]net.Addr, 0, len(cand))
+	var down []net.Addr
+	for _, a := range cand {
+		if zs.healthy(a, now) {
+			addrs = append(addrs, a)
+		} else {
+			down = append(down, a)
+		}
+	}
+	addrs = append(addrs, down...)
+	if len(addrs) > n {
+		addrs = addrs[:n]
+	}
+	return addrs, nil
+}
+
+// Each iterates over every server in every zone.
+func (zs *ZoneSelector) Each(f func(net.Addr) error) error {
+	zs.mu.RLock()
+	defer zs.mu.RUnlock()
+	for _, zone := range zs.names {
+		for _, a := range zs.zones[zone] {
+			if err := f(a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package memcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestZoneSelectorPrefersLocalZone(t *testing.T) {
+	zs := &ZoneSelector{LocalZone: "b"}
+	err := zs.SetZones(map[string][]string{
+		"a": {"127.0.0.1:1", "127.0.0.1:2"},
+		"b": {"127.0.0.1:3", "127.0.0.1:4"},
+		"c": {"127.0.0.1:5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"foo", "bar", "baz"} {
+		addr, err := zs.PickServer(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if z := zs.ZoneOf(addr); z != "b" {
+			t.Errorf("PickServer(%q) = %v in zone %q, want zone b", key, addr, z)
+		}
+		addrs, _ := zs.readOrder(key, 3)
+		var zones []string
+		for _, a := range addrs {
+			zones = append(zones, zs.ZoneOf(a))
+		}
+		if len(zones) != 3 || zones[0] != "b" || zones[1] != "a" || zones[2] != "c" {
+			t.Errorf("readOrder(%q) zones = %q, want [b a c]", key, zones)
+		}
+	}
+
+	// A failed local server is avoided by reads until its cooldown
+	// ends. Writes stay on it.
+	zs.FailureCooldown = 50 * time.Millisecond
+	local, _ := zs.PickServer("foo")
+	zs.ObserveResult(local, errors.New("connection reset"))
+	if addr, _ := zs.PickReadServer("foo"); zs.ZoneOf(addr) != "a" {
+		t.Errorf("after local failure, picked %v in zone %q for reads, want zone a", addr, zs.ZoneOf(addr))
+	}
+	if addr, _ := zs.PickServer("foo"); addr.String() != local.String() {
+		t.Errorf("after local failure, picked %v for writes, want local %v", addr, local)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if addr, _ := zs.PickReadServer("foo"); addr.String() != local.String() {
+		t.Errorf("after cooldown, picked %v, want local %v", addr, local)
+	}
+
+	// Cache misses don't make a server unhealthy.
+	zs.ObserveResult(local, ErrCacheMiss)
+	if addr, _ := zs.PickReadServer("foo"); addr.String() != local.String() {
+		t.Errorf("after cache miss, picked %v, want local %v", addr, local)
+	}
+}
+
+func TestZoneSelectorFallbackMetrics(t *testing.T) {
+	dead := newStubServer(t, endHandler)
+	dead.ln.Close()
+	remote := newStubServer(t, endHandler)
+
+	zs := &ZoneSelector{LocalZone: "rack1", FailureCooldown: time.Minute}
+	if err := zs.SetZones(map[string][]string{
+		"rack1": {dead.Addr()},
+		"rack2": {remote.Addr()},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c := NewFromSelector(zs)
+
+	if _, err := c.Get("foo", 1); err == nil {
+		t.Fatal("Get from dead local server: want error")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("foo", 1); err != nil {
+			t.Fatalf("Get after local failure: %v", err)
+		}
+	}
+	m := c.Metrics()
+	if m.ZoneRequests["rack2"] != 3 || m.ZoneRequests["rack1"] != 0 {
+		t.Errorf("ZoneRequests = %v, want 3 served by rack2", m.ZoneRequests)
+	}
+
+	// Writes don't fall back to the other zone.
+	if err := c.Set(&Item{Key: "foo", Value: []byte("v")}); err == nil {
+		t.Errorf("Set with dead local server: want error")
+	}
+	if m := c.Metrics(); m.ZoneRequests["rack2"] != 3 {
+		t.Errorf("ZoneRequests after Set = %v, want Set not served by rack2", m.ZoneRequests)
+	}
+}
@@ -0,0 +1,172 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxZsoltPayload is the largest payload a Zsolt frame can carry: its
+// header gives the length in 8-byte words in a single byte.
+const maxZsoltPayload = 255 * 8
+
+// ErrFrameTooLarge is returned when a request doesn't fit in a Zsolt
+// frame. Nothing is sent to the server.
+var ErrFrameTooLarge = errors.New("memcache: request too large for a Zsolt frame")
+
+// zsoltPadding fills the payload of a Zsolt frame up to a multiple of 8
+// bytes.
+var zsoltPadding [8]byte
+
+// zsoltMiss is how a Zsolt server may answer a get for a missing key.
+var zsoltMiss = [8]byte{'-', '-', '-', '-', '-', '-', '-', '-'}
+
+// writeFramed writes a command, made of the concatenated parts, to w.
+// The command is wrapped in a Zsolt frame if the client uses the Zsolt
+// protocol.
+func (c *Client) writeFramed(w *bufio.Writer, parts ...[]byte) error {
+	length := 0
+	for _, p := range parts {
+		length += len(p)
+	}
+	padLen := 0
+	if length%8 != 0 {
+		padLen = 8 - length%8
+	}
+	if c.UseZsolt {
+		if length+padLen > maxZsoltPayload {
+			return ErrFrameTooLarge
+		}
+		zheader := []byte{0xFF, 0xFF, 0, 0, byte((length + padLen) / 8), 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0}
+		if _, err := w.Write(zheader); err != nil {
+			return err
+		}
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	if !c.UseZsolt {
+		return nil
+	}
+	_, err := w.Write(zsoltPadding[:padLen])
+	return err
+}
+
+// readResponseLine reads the single-line response to a command, skipping
+// the 8 dummy bytes that precede it in the Zsolt protocol.
+func (c *Client) readResponseLine(r *bufio.Reader) ([]byte, error) {
+	if c.UseZsolt {
+		if _, err := r.Discard(8); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil || bytes.IndexByte(line, '\r') >= 0 {
+			return line, err
+		}
+	}
+}
+
+// roundTrip sends the command cmd, framed as the client's protocol
+// requires, and reads the single-line response.
+func (c *Client) roundTrip(rw *bufio.ReadWriter, cmd []byte) ([]byte, error) {
+	if err := c.writeFramed(rw.Writer, cmd); err != nil {
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+	return c.readResponseLine(rw.Reader)
+}
+
+// readValues reads a response made of VALUE lines, each followed by
+// its data block, up to END, and calls cb for each item.
+func (c *Client) readValues(r *bufio.Reader, cmd string, cb func(*Item)) error {
+	return c.readValuesInto(r, cmd, nil, cb)
+}
+
+// readValuesInto is readValues, reading the value of the first item into
+// buf if it fits. In the Zsolt protocol, a miss may also be answered with
+// the 8-byte miss marker instead of END, and the response to a ret is
+// padded to a multiple of 8 bytes.
+func (c *Client) readValuesInto(r *bufio.Reader, cmd string, buf []byte, cb func(*Item)) error {
+	if c.UseZsolt {
+		if _, err := r.Discard(8); err != nil {
+			return err
+		}
+		if p, err := r.Peek(4); err == nil && bytes.Equal(p, zsoltMiss[:4]) {
+			_, err := r.Discard(len(zsoltMiss))
+			return err
+		}
+	}
+	n := 0 // bytes read, for the padding of ret responses
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		n += len(line)
+		if bytes.IndexByte(line, '\r') < 0 {
+			// Zsolt padding.
+			continue
+		}
+		line = bytes.TrimLeft(line, "\x00")
+		if bytes.Equal(line, resultEnd) {
+			return c.discardRetPadding(r, cmd, n)
+		}
+		if err := responseError(cmd, line); err != nil {
+			if perr := c.discardRetPadding(r, cmd, n); perr != nil {
+				return perr
+			}
+			return err
+		}
+		it := new(Item)
+		size, err := scanGetResponseLine(line, it)
+		if err != nil {
+			return err
+		}
+		if cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		it.Value = buf[:size]
+		if _, err := io.ReadFull(r, it.Value); err != nil {
+			return err
+		}
+		end, err := r.Peek(len(crlf))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(end, crlf) {
+			return fmt.Errorf("memcache: corrupt %s result read", cmd)
+		}
+		r.Discard(len(crlf))
+		n += size + len(crlf)
+		if c.Layout != nil {
+			it.Value = c.Layout.strip(it.Value)
+		}
+		buf = nil
+		cb(it)
+	}
+}
+
+// discardRetPadding discards the padding that follows the n bytes of a
+// Zsolt response to a ret.
+func (c *Client) discardRetPadding(r *bufio.Reader, cmd string, n int) error {
+	if !c.UseZsolt || cmd != "ret" || n%8 == 0 {
+		return nil
+	}
+	_, err := r.Discard(8 - n%8)
+	return err
+}
+
+// trimLine strips the line ending and any leading Zsolt padding from a
+// response line.
+func trimLine(line []byte) []byte {
+	return bytes.TrimRight(bytes.TrimLeft(line, "\x00"), "\r\n")
+}
@@ -86,18 +86,6 @@ func client(wg * sync.WaitGroup, s chan bool, mc *memcache.Client, config *confi
 
    stats := statistics{reqs: 0, sets: 0, gets: 0, setErrors: 0, getErrors: 0}
 
-   // Open a connection by issuing a set/get
-   var err error
-   if config.regex {
-      err = mc.SetJSON(&memcache.Item{Key: keys[0], Value: value})
-   } else {
-      err = mc.Set(&memcache.Item{Key: keys[0], Value: value})
-   }
-   if err != nil {
-      fmt.Println("Error on open/set connection: ", err.Error())
-      //os.Exit(1)
-   }
-
    // Wait for start signal
    startsig := <-s
    if !startsig {
@@ -196,18 +184,6 @@ func scan_client(wg * sync.WaitGroup, s chan bool, mc *memcache.Client, config *
 
    stats := statistics{reqs: 0, sets: 0, gets: 0, miss: 0, setErrors: 0, getErrors: 0}
 
-   // Open a connection by issuing a set/get
-   var err error
-   if config.regex {
-      err = mc.SetJSON(&memcache.Item{Key: keys[0], Value: value})
-   } else {
-      err = mc.Set(&memcache.Item{Key: keys[0], Value: value})
-   }
-   if err != nil {
-      fmt.Println("Error on open/set connection: ", err.Error())
-      //os.Exit(1)
-   }
-
    // Wait for start signal
    startsig := <-s
    if !startsig {
@@ -408,6 +384,14 @@ func main() {
    mc.Timeout = 5000 * time.Millisecond
    mc.UseZsolt = *zsoltPtr
 
+   // Open the connections before the clients start
+   if !useUDP {
+      if err := mc.Warmup(config.numClients); err != nil {
+         fmt.Println("Error on warmup: ", err.Error())
+         //os.Exit(1)
+      }
+   }
+
    wg := new(sync.WaitGroup)
    start := make(chan bool)
    statschan := make(chan statistics)
@@ -454,6 +438,7 @@ func main() {
    }
    wg.Wait()
    duration := time.Since(starttime).Seconds()
+   mc.Close()
 
    fmt.Println("----------------------------")
    fmt.Printf("Throughput[KReq/s]: %2f\n", float64(gstats.reqs) / duration / 1000)